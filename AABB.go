@@ -69,14 +69,14 @@ func (aabb *AABB) hit_test(ray *Ray, ray_tmin float64, ray_tmax float64) (ok boo
 			if t0 > ray_tmin {
 				ray_tmin = t0
 			}
-			if t1 > ray_tmax {
+			if t1 < ray_tmax {
 				ray_tmax = t1
 			}
 		} else {
 			if t1 > ray_tmin {
 				ray_tmin = t1
 			}
-			if t0 > ray_tmax {
+			if t0 < ray_tmax {
 				ray_tmax = t0
 			}
 		}
@@ -90,6 +90,29 @@ func (aabb *AABB) hit_test(ray *Ray, ray_tmin float64, ray_tmax float64) (ok boo
 	return true
 }
 
+// Same as hit_test, but with the reciprocal of the ray direction worked out once by the caller.
+func (aabb *AABB) hit_test_inv(origin, inv_dir *Vec3, ray_tmin float64, ray_tmax float64) (ok bool) {
+	for axis := 0; axis < 3; axis++ {
+		t0 := (aabb.minVec[axis] - origin[axis]) * inv_dir[axis]
+		t1 := (aabb.maxVec[axis] - origin[axis]) * inv_dir[axis]
+		if inv_dir[axis] < 0 {
+			t0, t1 = t1, t0
+		}
+
+		if t0 > ray_tmin {
+			ray_tmin = t0
+		}
+		if t1 < ray_tmax {
+			ray_tmax = t1
+		}
+		if ray_tmax <= ray_tmin {
+			return false
+		}
+	}
+
+	return true
+}
+
 // Modify AABB by an offset
 func (bbox *AABB) AddOffset(offset *Vec3) *AABB {
 	return NewAABB(*bbox.minVec.Add(offset), *bbox.maxVec.Add(offset))
@@ -107,3 +130,49 @@ func NewEmptyAABB() *AABB {
 func NewUniverseAABB() *AABB {
 	return &AABB{*NewVec3(math.Inf(-1), math.Inf(-1), math.Inf(-1)), *NewVec3(math.Inf(1), math.Inf(1), math.Inf(1))}
 }
+
+// Center of the AABB
+func (bbox *AABB) center() Vec3 {
+	return *bbox.minVec.Add(&bbox.maxVec).Scale(0.5)
+}
+
+// Surface area of the AABB, an empty box has no area.
+func (bbox *AABB) surface_area() float64 {
+	d := bbox.maxVec.Sub(&bbox.minVec)
+	if d[0] < 0 || d[1] < 0 || d[2] < 0 {
+		return 0
+	}
+	return 2 * (d[0]*d[1] + d[1]*d[2] + d[2]*d[0])
+}
+
+// In-place merge of another AABB into this one
+func (bbox *AABB) IMerge(other *AABB) {
+	for axis := 0; axis < 3; axis++ {
+		bbox.minVec[axis] = math.Min(bbox.minVec[axis], other.minVec[axis])
+		bbox.maxVec[axis] = math.Max(bbox.maxVec[axis], other.maxVec[axis])
+	}
+}
+
+// Clip the ray's [ray_tmin, ray_tmax] interval to the part inside the box, ok is false if it misses.
+func (aabb *AABB) clip(ray *Ray, ray_tmin float64, ray_tmax float64) (t0, t1 float64, ok bool) {
+	for axis := 0; axis < 3; axis++ {
+		adinv := 1.0 / ray.direction[axis]
+		near := (aabb.minVec[axis] - ray.origin[axis]) * adinv
+		far := (aabb.maxVec[axis] - ray.origin[axis]) * adinv
+		if near > far {
+			near, far = far, near
+		}
+
+		if near > ray_tmin {
+			ray_tmin = near
+		}
+		if far < ray_tmax {
+			ray_tmax = far
+		}
+		if ray_tmax < ray_tmin {
+			return 0, 0, false
+		}
+	}
+
+	return ray_tmin, ray_tmax, true
+}
@@ -0,0 +1,43 @@
+package main
+
+// Acceleration structures the top level objects of a scene can be put in before rendering.
+type Accelerator int
+
+const (
+	AccelNone   Accelerator = iota // Render the world as given
+	AccelList                      // Brute force, testing every object. The reference for the others.
+	AccelBVH                       // Flattened SAH BVH
+	AccelGrid                      // Multi-level uniform grid
+	AccelKDTree                    // SAH kd-tree
+)
+
+// Put objects in the chosen acceleration structure.
+func NewAccelerator(kind Accelerator, objects []Hittable) Hittable {
+	switch kind {
+	case AccelList:
+		return NewList(objects...)
+	case AccelBVH:
+		return NewSAHBVH(objects, 4, 1, 1).Flatten()
+	case AccelGrid:
+		return NewGrid(objects)
+	case AccelKDTree:
+		return NewKDTree(objects)
+	default:
+		return NewList(objects...)
+	}
+}
+
+func (kind Accelerator) String() string {
+	switch kind {
+	case AccelList:
+		return "list"
+	case AccelBVH:
+		return "bvh"
+	case AccelGrid:
+		return "grid"
+	case AccelKDTree:
+		return "kd-tree"
+	default:
+		return "none"
+	}
+}
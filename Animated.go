@@ -0,0 +1,161 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// An object moving between keyframes over ray.time, for motion blur of anything, not just spheres.
+// Translation and scale are interpolated linearly and rotation with Slerp, applied scale first, then rotation, then translation.
+type AnimatedTransform struct {
+	object    Hittable
+	keyframes []Keyframe // In order of time
+	bbox      AABB       // Covers the object at every time
+}
+
+// Where an object is at one time.
+type Keyframe struct {
+	time        float64
+	translation Vec3
+	rotation    Quaternion
+	scale       Vec3
+}
+
+// Samples per keyframe interval taken to bound the motion.
+const animated_bound_samples = 32
+
+// Place an object at time. A nil rotation is no rotation and a nil scale is 1 along every axis.
+func NewKeyframe(time float64, translation *Vec3, rotation *Quaternion, scale *Vec3) Keyframe {
+	keyframe := Keyframe{time: time, translation: *translation, rotation: Quaternion{0, 0, 0, 1}, scale: *NewVec3(1, 1, 1)}
+	if rotation != nil {
+		keyframe.rotation = *NewQuaternion(rotation[0], rotation[1], rotation[2], rotation[3])
+	}
+	if scale != nil {
+		keyframe.scale = *scale
+	}
+	return keyframe
+}
+
+// Animate object through keyframes. Before the first keyframe and after the last one it stays put.
+func NewAnimatedTransform(object Hittable, keyframes ...Keyframe) *AnimatedTransform {
+	sorted := append([]Keyframe(nil), keyframes...)
+	if len(sorted) == 0 {
+		sorted = append(sorted, NewKeyframe(0, NewVec3(0, 0, 0), nil, nil))
+	}
+	sort.SliceStable(sorted, func(a, b int) bool { return sorted[a].time < sorted[b].time })
+
+	anim := AnimatedTransform{object: object, keyframes: sorted}
+	anim.bbox = *anim.motion_bounds()
+	return &anim
+}
+
+// Object to world matrix at time, and its inverse. ok is false if the object is scaled to nothing.
+func (anim *AnimatedTransform) Matrix(time float64) (matrix, inverse Mat4, ok bool) {
+	key := anim.at(time)
+	rotation := key.rotation.Mat4()
+	matrix, inverse = IdentityMat4(), IdentityMat4()
+	for row := 0; row < 3; row++ {
+		if key.scale[row] == 0 {
+			return matrix, inverse, false
+		}
+		for column := 0; column < 3; column++ {
+			matrix[row][column] = rotation[row][column] * key.scale[column]
+			// Undo the translation, then the rotation by its transpose, then the scale.
+			inverse[row][column] = rotation[column][row] / key.scale[row]
+		}
+		matrix[row][3] = key.translation[row]
+	}
+	offset := inverse.TransformVector(&key.translation)
+	for row := 0; row < 3; row++ {
+		inverse[row][3] = -offset[row]
+	}
+	return matrix, inverse, true
+}
+
+// Keyframe interpolated at time.
+func (anim *AnimatedTransform) at(time float64) Keyframe {
+	keys := anim.keyframes
+	next := sort.Search(len(keys), func(i int) bool { return keys[i].time > time })
+	if next == 0 {
+		return keys[0]
+	}
+	if next == len(keys) {
+		return keys[len(keys)-1]
+	}
+
+	k0, k1 := &keys[next-1], &keys[next]
+	t := (time - k0.time) / (k1.time - k0.time)
+	return Keyframe{
+		time:        time,
+		translation: *k0.translation.Scale(1 - t).Add(k1.translation.Scale(t)),
+		rotation:    *Slerp(&k0.rotation, &k1.rotation, t),
+		scale:       *k0.scale.Scale(1 - t).Add(k1.scale.Scale(t)),
+	}
+}
+
+func (anim *AnimatedTransform) hit(ray *Ray, ray_tmin float64, ray_tmax float64, record *Hit) (ok bool) {
+	matrix, inverse, ok := anim.Matrix(ray.time)
+	if !ok {
+		return false
+	}
+	if !transformed_hit(anim.object, &matrix, &inverse, ray, ray_tmin, ray_tmax, record) {
+		return false
+	}
+	// Lights moving with ray.time aren't in the light tree.
+	record.unsampled = true
+	return true
+}
+
+func (anim *AnimatedTransform) bounding_box() (bounds *AABB) {
+	return &anim.bbox
+}
+
+// Bounds of the object over all its motion. Between keyframes the object is bounded at evenly spaced samples,
+// then padded by how far a point's curved path can stray from the straight line between two samples.
+func (anim *AnimatedTransform) motion_bounds() *AABB {
+	bounds := NewEmptyAABB()
+	add := func(time float64) {
+		if matrix, _, ok := anim.Matrix(time); ok {
+			bounds.IMerge(transformed_bounds(anim.object, &matrix))
+		}
+	}
+
+	// Farthest any point of the object gets from its origin.
+	object_box := anim.object.bounding_box()
+	reach := 0.0
+	for axis := 0; axis < 3; axis++ {
+		reach += math.Max(object_box.minVec[axis]*object_box.minVec[axis], object_box.maxVec[axis]*object_box.maxVec[axis])
+	}
+	reach = math.Sqrt(reach)
+
+	add(anim.keyframes[0].time)
+	padding := 0.0
+	for i := 1; i < len(anim.keyframes); i++ {
+		k0, k1 := &anim.keyframes[i-1], &anim.keyframes[i]
+		if k1.time == k0.time {
+			add(k1.time)
+			continue
+		}
+		for sample := 1; sample <= animated_bound_samples; sample++ {
+			add(k0.time + (k1.time-k0.time)*float64(sample)/animated_bound_samples)
+		}
+
+		// A path p(t) strays at most h²/8 times the largest |p''| from its chord over a step h. Translation is linear,
+		// so the bend comes from turning at rate omega while the scale changes.
+		omega := 2 * safe_acos(math.Abs(k0.rotation.Dot(&k1.rotation)))
+		scale_change := k1.scale.Sub(&k0.scale).Magnitude()
+		largest_scale := 0.0
+		for axis := 0; axis < 3; axis++ {
+			largest_scale = math.Max(largest_scale, math.Max(math.Abs(k0.scale[axis]), math.Abs(k1.scale[axis])))
+		}
+		step := 1.0 / animated_bound_samples
+		bend := (omega*omega*largest_scale + 2*omega*scale_change) * reach
+		padding = math.Max(padding, step*step/8*bend)
+	}
+
+	if padding > 0 {
+		pad := NewVec3(padding, padding, padding)
+		bounds = NewAABB(*bounds.minVec.Sub(pad), *bounds.maxVec.Add(pad))
+	}
+	return bounds
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Put rendered frames together into one animated image for previews, an animated GIF or an APNG.
+// GIFs only have 256 colours, so a palette is picked for all the frames by median cut and the frames are dithered onto it.
+
+// Most pixels of each frame looked at when picking the GIF palette.
+const gif_palette_samples = 20000
+
+// Save frames as an animated image playing at frame_rate, a GIF if filename ends in .gif and an APNG otherwise.
+func SaveAnimated(filename string, frames []image.Image, frame_rate float64) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(filename), ".gif") {
+		err = WriteGif(file, frames, frame_rate)
+	} else {
+		err = WriteApng(file, frames, frame_rate)
+	}
+	if close_err := file.Close(); err == nil {
+		err = close_err
+	}
+	return err
+}
+
+// Write frames as a looping GIF, Floyd-Steinberg dithered onto one palette shared by every frame.
+func WriteGif(writer io.Writer, frames []image.Image, frame_rate float64) error {
+	if len(frames) == 0 {
+		return fmt.Errorf("no frames to write")
+	}
+	if !(frame_rate > 0) {
+		return fmt.Errorf("frame rate must be positive, not %v", frame_rate)
+	}
+	palette := median_cut_palette(frames, 256)
+
+	// Delays are in hundredths of a second.
+	delay := max(1, int(math.Round(100/frame_rate)))
+	animation := gif.GIF{LoopCount: 0}
+	for _, frame := range frames {
+		paletted := image.NewPaletted(frame.Bounds(), palette)
+		draw.FloydSteinberg.Draw(paletted, frame.Bounds(), frame, frame.Bounds().Min)
+		animation.Image = append(animation.Image, paletted)
+		animation.Delay = append(animation.Delay, delay)
+	}
+	return gif.EncodeAll(writer, &animation)
+}
+
+// A box of colours in RGB space, split in two along its longest side until there are enough of them.
+type color_box struct {
+	colors [][3]uint8
+}
+
+// The widest channel of the box and how wide it is.
+func (box *color_box) widest() (channel int, width int) {
+	for c := 0; c < 3; c++ {
+		low, high := 255, 0
+		for _, rgb := range box.colors {
+			low, high = min(low, int(rgb[c])), max(high, int(rgb[c]))
+		}
+		if high-low > width || c == 0 {
+			channel, width = c, high-low
+		}
+	}
+	return channel, width
+}
+
+// Palette of up to size colours representing the frames, by median cut over a sample of their pixels.
+func median_cut_palette(frames []image.Image, size int) color.Palette {
+	var colors [][3]uint8
+	for _, frame := range frames {
+		bounds := frame.Bounds()
+		pixels := bounds.Dx() * bounds.Dy()
+		step := max(1, pixels/gif_palette_samples)
+		for i := 0; i < pixels; i += step {
+			r, g, b, _ := frame.At(bounds.Min.X+i%bounds.Dx(), bounds.Min.Y+i/bounds.Dx()).RGBA()
+			colors = append(colors, [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)})
+		}
+	}
+
+	// Split the box with the widest spread of colours at its median each time.
+	boxes := []color_box{{colors}}
+	for len(boxes) < size {
+		best, best_width := -1, 0
+		for i := range boxes {
+			if len(boxes[i].colors) < 2 {
+				continue
+			}
+			if _, width := boxes[i].widest(); width > best_width {
+				best, best_width = i, width
+			}
+		}
+		if best < 0 {
+			break // Every box is a single colour
+		}
+
+		box := boxes[best]
+		channel, _ := box.widest()
+		sort.Slice(box.colors, func(a, b int) bool { return box.colors[a][channel] < box.colors[b][channel] })
+		median := len(box.colors) / 2
+		boxes[best] = color_box{box.colors[:median]}
+		boxes = append(boxes, color_box{box.colors[median:]})
+	}
+
+	// Each box is represented by its average colour.
+	palette := make(color.Palette, 0, len(boxes))
+	for _, box := range boxes {
+		if len(box.colors) == 0 {
+			continue
+		}
+		var sum [3]int
+		for _, rgb := range box.colors {
+			for c := 0; c < 3; c++ {
+				sum[c] += int(rgb[c])
+			}
+		}
+		n := len(box.colors)
+		palette = append(palette, color.RGBA{uint8(sum[0] / n), uint8(sum[1] / n), uint8(sum[2] / n), 0xff})
+	}
+	if len(palette) == 0 {
+		palette = append(palette, color.RGBA{0, 0, 0, 0xff})
+	}
+	return palette
+}
+
+// Write frames as a looping APNG. Each frame is encoded as an ordinary PNG and its image data
+// moved into the animation chunks, so every frame must be the same size.
+func WriteApng(writer io.Writer, frames []image.Image, frame_rate float64) error {
+	if len(frames) == 0 {
+		return fmt.Errorf("no frames to write")
+	}
+	if !(frame_rate > 0) {
+		return fmt.Errorf("frame rate must be positive, not %v", frame_rate)
+	}
+
+	var out bytes.Buffer
+	out.Write([]byte("\x89PNG\r\n\x1a\n"))
+	sequence := uint32(0)
+	var header []byte
+
+	delay_num, delay_den := apng_delay(frame_rate)
+
+	for index, frame := range frames {
+		var encoded bytes.Buffer
+		if err := png.Encode(&encoded, frame); err != nil {
+			return err
+		}
+		chunks, err := png_chunks(encoded.Bytes())
+		if err != nil {
+			return err
+		}
+
+		if index == 0 {
+			header = chunks[0].data
+			write_png_chunk(&out, "IHDR", header)
+			var actl [8]byte
+			binary.BigEndian.PutUint32(actl[0:], uint32(len(frames)))
+			binary.BigEndian.PutUint32(actl[4:], 0) // Loop forever
+			write_png_chunk(&out, "acTL", actl[:])
+		} else if !bytes.Equal(chunks[0].data, header) {
+			return fmt.Errorf("frame %d doesn't match the size or colour type of the first frame", index)
+		}
+
+		// Frame control: sequence, size, offset, delay, dispose and blend operations.
+		var fctl [26]byte
+		binary.BigEndian.PutUint32(fctl[0:], sequence)
+		copy(fctl[4:12], header[0:8]) // Width and height, as in IHDR
+		binary.BigEndian.PutUint16(fctl[20:], delay_num)
+		binary.BigEndian.PutUint16(fctl[22:], delay_den)
+		write_png_chunk(&out, "fcTL", fctl[:])
+		sequence++
+
+		for _, chunk := range chunks {
+			switch {
+			case chunk.kind != "IDAT":
+				continue
+			case index == 0:
+				write_png_chunk(&out, "IDAT", chunk.data)
+			default:
+				data := make([]byte, 4+len(chunk.data))
+				binary.BigEndian.PutUint32(data, sequence)
+				copy(data[4:], chunk.data)
+				write_png_chunk(&out, "fdAT", data)
+				sequence++
+			}
+		}
+	}
+	write_png_chunk(&out, "IEND", nil)
+
+	_, err := writer.Write(out.Bytes())
+	return err
+}
+
+// APNG frame delays are a fraction of a second with a 16 bit numerator and denominator. This is 1/frame_rate exactly
+// for whole frame rates, and otherwise the closest fraction that fits, found from its continued fraction.
+func apng_delay(frame_rate float64) (num, den uint16) {
+	const limit = math.MaxUint16
+	if frame_rate >= 1 && frame_rate <= limit && frame_rate == math.Trunc(frame_rate) {
+		return 1, uint16(frame_rate)
+	}
+
+	// Each convergent h1/k1 is the closest fraction with a denominator up to k1, stop before one doesn't fit.
+	delay := 1 / frame_rate
+	h0, h1, k0, k1 := 0.0, 1.0, 1.0, 0.0
+	for x := delay; ; {
+		a := math.Floor(x)
+		if a*h1+h0 > limit || a*k1+k0 > limit {
+			// The largest step towards the next convergent that still fits can be closer than the last one.
+			m := math.Inf(1)
+			if h1 > 0 {
+				m = math.Floor((limit - h0) / h1)
+			}
+			if k1 > 0 {
+				m = math.Min(m, math.Floor((limit-k0)/k1))
+			}
+			h, k := m*h1+h0, m*k1+k0
+			if m >= 1 && (k1 == 0 || math.Abs(h/k-delay) < math.Abs(h1/k1-delay)) {
+				h1, k1 = h, k
+			}
+			break
+		}
+		h0, h1, k0, k1 = h1, a*h1+h0, k1, a*k1+k0
+		if x-a < 1e-9 {
+			break
+		}
+		x = 1 / (x - a)
+	}
+
+	// Too fast to show shorter than the smallest delay.
+	if h1 < 1 {
+		return 1, limit
+	}
+	return uint16(h1), uint16(k1)
+}
+
+type png_chunk struct {
+	kind string
+	data []byte
+}
+
+// Chunks of an encoded PNG, starting with IHDR.
+func png_chunks(data []byte) ([]png_chunk, error) {
+	var chunks []png_chunk
+	for offset := 8; offset+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		end := offset + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, fmt.Errorf("png chunk runs past the end of the data")
+		}
+		chunks = append(chunks, png_chunk{string(data[offset+4 : offset+8]), data[offset+8 : offset+8+length]})
+		offset = end
+	}
+	if len(chunks) == 0 || chunks[0].kind != "IHDR" {
+		return nil, fmt.Errorf("png doesn't start with IHDR")
+	}
+	return chunks, nil
+}
+
+// Append a chunk with its length and CRC.
+func write_png_chunk(out *bytes.Buffer, kind string, data []byte) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(data)))
+	out.Write(length[:])
+
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(data)
+	out.WriteString(kind)
+	out.Write(data)
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	out.Write(sum[:])
+}
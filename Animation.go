@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"sort"
+)
+
+// Render a numbered image for every frame of an animation. Frame f starts at time f / frame_rate,
+// and its rays are spread over the part of the frame the shutter is open for, so anything moving
+// with ray.time (AnimatedTransform, NewMovingSphere) blurs by how far it moves in that time.
+type Animation struct {
+	first, last   int     // Frames to render, inclusive
+	frame_rate    float64 // Frames per unit of time
+	shutter_open  float64 // When the shutter opens and closes, as fractions of a frame after its start
+	shutter_close float64
+
+	// Builds the world and camera for the frame starting at time. The camera's own shutter is replaced by the animation's.
+	scene func(time float64) (Hittable, *camera)
+}
+
+// Animation of frames first to last at frame_rate, with the shutter open for the first half of each frame.
+func NewAnimation(first, last int, frame_rate float64, scene func(time float64) (Hittable, *camera)) *Animation {
+	return &Animation{
+		first:         first,
+		last:          last,
+		frame_rate:    frame_rate,
+		shutter_open:  0,
+		shutter_close: 0.5,
+		scene:         scene,
+	}
+}
+
+// Time frame starts at
+func (anim *Animation) FrameTime(frame int) float64 {
+	return float64(frame) / anim.frame_rate
+}
+
+// Render every frame into dir as frame_0001.png and so on, numbered by frame.
+func (anim *Animation) Render(dir string, sample_per_pixel, max_depth int) error {
+	return anim.each_frame(sample_per_pixel, max_depth, func(frame int, img *image.NRGBA) error {
+		filename := filepath.Join(dir, fmt.Sprintf("frame_%04d.png", frame))
+		if err := save_png(img, filename); err != nil {
+			return err
+		}
+		fmt.Println("Rendered", filename)
+		return nil
+	})
+}
+
+// Render every frame into one looping animated image, a GIF if filename ends in .gif and an APNG otherwise.
+// The frames are kept in memory until they are all rendered.
+func (anim *Animation) RenderAnimated(filename string, sample_per_pixel, max_depth int) error {
+	var frames []image.Image
+	err := anim.each_frame(sample_per_pixel, max_depth, func(frame int, img *image.NRGBA) error {
+		frames = append(frames, img)
+		fmt.Println("Rendered frame", frame)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return SaveAnimated(filename, frames, anim.frame_rate)
+}
+
+// Render the frames in order, handing each image to done.
+func (anim *Animation) each_frame(sample_per_pixel, max_depth int, done func(frame int, img *image.NRGBA) error) error {
+	if anim.frame_rate <= 0 {
+		return fmt.Errorf("frame rate must be positive, not %v", anim.frame_rate)
+	}
+	for frame := anim.first; frame <= anim.last; frame++ {
+		start := anim.FrameTime(frame)
+		world, cam := anim.scene(start)
+		cam.shutter_open = start + anim.shutter_open/anim.frame_rate
+		cam.shutter_close = start + anim.shutter_close/anim.frame_rate
+
+		if err := done(frame, cam.render_image(world, sample_per_pixel, max_depth)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Where the camera is at one time of an animation.
+type ViewKeyframe struct {
+	time float64
+	view View
+}
+
+// View at time, moving in straight lines between keyframes and staying put before the first and after the last.
+func AnimateView(time float64, keyframes ...ViewKeyframe) View {
+	keys := append([]ViewKeyframe(nil), keyframes...)
+	if len(keys) == 0 {
+		return View{}
+	}
+	sort.SliceStable(keys, func(a, b int) bool { return keys[a].time < keys[b].time })
+
+	next := sort.Search(len(keys), func(i int) bool { return keys[i].time > time })
+	if next == 0 {
+		return keys[0].view
+	}
+	if next == len(keys) {
+		return keys[len(keys)-1].view
+	}
+
+	v0, v1 := &keys[next-1].view, &keys[next].view
+	t := (time - keys[next-1].time) / (keys[next].time - keys[next-1].time)
+	lerp := func(a, b *Vec3) Vec3 {
+		return *a.Scale(1 - t).Add(b.Scale(t))
+	}
+	view := View{
+		lookfrom:     lerp(&v0.lookfrom, &v1.lookfrom),
+		lookat:       lerp(&v0.lookat, &v1.lookat),
+		vup:          lerp(&v0.vup, &v1.vup),
+		vfov:         v0.vfov + (v1.vfov-v0.vfov)*t,
+		projection:   v0.projection,
+		ortho_height: v0.ortho_height + (v1.ortho_height-v0.ortho_height)*t,
+		aspect_ratio: v0.aspect_ratio,
+		mirrored:     v0.mirrored,
+	}
+	if view.vup.near_zero() {
+		view.vup = v0.vup
+	}
+	return view
+}
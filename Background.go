@@ -0,0 +1,55 @@
+package main
+
+type Background interface {
+	// Calculates the color seen by a ray that escapes the scene.
+	value(ray *Ray) Vec3
+}
+
+// Constant Color Background
+type ConstantBackground struct {
+	color Vec3
+}
+
+// Create a background with the same color in every direction
+func NewConstantBackground(color Vec3) *Background {
+	var background Background = &ConstantBackground{color}
+	return &background
+}
+
+func (constant *ConstantBackground) value(ray *Ray) Vec3 {
+	return constant.color
+}
+
+// Vertical Gradient Background
+type GradientBackground struct {
+	bottom, top Vec3 // Colors seen when looking straight down and straight up
+}
+
+// Create a background that blends linearly from bottom to top by the ray's y direction
+func NewGradientBackground(bottom, top Vec3) *Background {
+	var background Background = &GradientBackground{bottom, top}
+	return &background
+}
+
+func (gradient *GradientBackground) value(ray *Ray) Vec3 {
+	unit_direction := ray.direction.Unit()
+	a := 0.5 * (unit_direction[1] + 1.0)
+	return *gradient.bottom.Scale(1.0 - a).Add(gradient.top.Scale(a))
+}
+
+// Texture Background, the texture is wrapped around a sphere at infinity.
+type TextureBackground struct {
+	texture *Texture
+}
+
+// Create a background from a texture mapped spherically, using the same UVs as a sphere.
+func NewTextureBackground(texture *Texture) *Background {
+	var background Background = &TextureBackground{texture}
+	return &background
+}
+
+func (tex *TextureBackground) value(ray *Ray) Vec3 {
+	unit_direction := *ray.direction.Unit()
+	u, v := get_sphere_uv(unit_direction)
+	return (*tex.texture).value(u, v, unit_direction)
+}
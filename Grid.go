@@ -0,0 +1,203 @@
+package main
+
+import "math"
+
+// Uniform grid accelerator, every cell lists the objects whose bounding boxes overlap it.
+// Cells that end up crowded get a finer grid of their own, making it a multi-level grid.
+type Grid struct {
+	bounds    AABB
+	res       [3]int // Number of cells along each axis
+	cell_size Vec3
+	cells     [][]Hittable // Cell (x, y, z) is at x + y*res[0] + z*res[0]*res[1]
+}
+
+// Cells per axis is about grid_density times the cube root of the object count, along the longest axis.
+const grid_density = 3.0
+
+// Most cells along any axis of a single level.
+const grid_max_res = 64
+
+// Cells with more objects than this are subdivided into a nested grid.
+const grid_subdivide = 32
+
+// Deepest level of nested grids.
+const grid_max_levels = 3
+
+func NewGrid(objects []Hittable) *Grid {
+	bounds := NewEmptyAABB()
+	for _, object := range objects {
+		bounds.IMerge(object.bounding_box())
+	}
+	return new_grid(objects, *bounds, 1)
+}
+
+func new_grid(objects []Hittable, bounds AABB, level int) *Grid {
+	grid := Grid{bounds: bounds}
+	if len(objects) == 0 {
+		return &grid
+	}
+
+	extent := bounds.maxVec.Sub(&bounds.minVec)
+	max_extent := math.Max(extent[0], math.Max(extent[1], extent[2]))
+	cells_per_unit := 0.0
+	if max_extent > 0 {
+		cells_per_unit = grid_density * math.Cbrt(float64(len(objects))) / max_extent
+	}
+	count := 1
+	for axis := 0; axis < 3; axis++ {
+		grid.res[axis] = max(1, min(grid_max_res, int(math.Round(extent[axis]*cells_per_unit))))
+		grid.cell_size[axis] = extent[axis] / float64(grid.res[axis])
+		count *= grid.res[axis]
+	}
+	grid.cells = make([][]Hittable, count)
+
+	// Add every object to each cell its bounding box overlaps.
+	for _, object := range objects {
+		box := object.bounding_box()
+		lo, hi := grid.cell_of(&box.minVec), grid.cell_of(&box.maxVec)
+		for z := lo[2]; z <= hi[2]; z++ {
+			for y := lo[1]; y <= hi[1]; y++ {
+				for x := lo[0]; x <= hi[0]; x++ {
+					index := grid.index(x, y, z)
+					grid.cells[index] = append(grid.cells[index], object)
+				}
+			}
+		}
+	}
+
+	if level >= grid_max_levels {
+		return &grid
+	}
+
+	// Crowded cells get their own grid, unless every object is in there and subdividing wouldn't thin them out.
+	for z := 0; z < grid.res[2]; z++ {
+		for y := 0; y < grid.res[1]; y++ {
+			for x := 0; x < grid.res[0]; x++ {
+				index := grid.index(x, y, z)
+				contents := grid.cells[index]
+				if len(contents) <= grid_subdivide || len(contents) == len(objects) {
+					continue
+				}
+
+				cell_bounds := AABB{
+					*grid.bounds.minVec.Add(NewVec3(float64(x), float64(y), float64(z)).Mult(&grid.cell_size)),
+					*grid.bounds.minVec.Add(NewVec3(float64(x+1), float64(y+1), float64(z+1)).Mult(&grid.cell_size)),
+				}
+				var nested Hittable = new_grid(contents, cell_bounds, level+1)
+				grid.cells[index] = []Hittable{nested}
+			}
+		}
+	}
+
+	return &grid
+}
+
+// Every object in the grid once, though objects overlapping several cells are listed in each of them.
+func (grid *Grid) unique_objects() []Hittable {
+	var objects []Hittable
+	seen := make(map[Hittable]bool)
+	var walk func(grid *Grid)
+	walk = func(grid *Grid) {
+		for _, cell := range grid.cells {
+			for _, object := range cell {
+				if nested, ok := object.(*Grid); ok {
+					walk(nested)
+				} else if !seen[object] {
+					seen[object] = true
+					objects = append(objects, object)
+				}
+			}
+		}
+	}
+	walk(grid)
+	return objects
+}
+
+// Index into cells
+func (grid *Grid) index(x, y, z int) int {
+	return x + y*grid.res[0] + z*grid.res[0]*grid.res[1]
+}
+
+// Cell containing a point, clamped to the grid.
+func (grid *Grid) cell_of(point *Vec3) [3]int {
+	var cell [3]int
+	for axis := 0; axis < 3; axis++ {
+		if grid.cell_size[axis] > 0 {
+			cell[axis] = int((point[axis] - grid.bounds.minVec[axis]) / grid.cell_size[axis])
+		}
+		cell[axis] = max(0, min(grid.res[axis]-1, cell[axis]))
+	}
+	return cell
+}
+
+// Walks the cells the ray passes through in order with a 3D-DDA, stopping once a hit lies within the current cell.
+func (grid *Grid) hit(ray *Ray, ray_tmin float64, ray_tmax float64, record *Hit) (ok bool) {
+	if len(grid.cells) == 0 {
+		return false
+	}
+
+	t0, t1, inside := grid.bounds.clip(ray, ray_tmin, ray_tmax)
+	if !inside {
+		return false
+	}
+
+	entry := ray.At(t0)
+	cell := grid.cell_of(&entry)
+
+	// Distance along the ray to the next cell boundary on each axis, how far apart the boundaries are, and which way to step.
+	var next_crossing, delta [3]float64
+	var step, out [3]int
+	for axis := 0; axis < 3; axis++ {
+		dir := ray.direction[axis]
+		if dir > 0 {
+			boundary := grid.bounds.minVec[axis] + float64(cell[axis]+1)*grid.cell_size[axis]
+			next_crossing[axis] = t0 + (boundary-entry[axis])/dir
+			delta[axis] = grid.cell_size[axis] / dir
+			step[axis], out[axis] = 1, grid.res[axis]
+		} else if dir < 0 {
+			boundary := grid.bounds.minVec[axis] + float64(cell[axis])*grid.cell_size[axis]
+			next_crossing[axis] = t0 + (boundary-entry[axis])/dir
+			delta[axis] = -grid.cell_size[axis] / dir
+			step[axis], out[axis] = -1, -1
+		} else {
+			next_crossing[axis] = math.Inf(1)
+			step[axis], out[axis] = 0, -1
+		}
+	}
+
+	closest_so_far := ray_tmax
+	anything := false
+	for {
+		for _, object := range grid.cells[grid.index(cell[0], cell[1], cell[2])] {
+			if object.hit(ray, ray_tmin, closest_so_far, record) {
+				anything = true
+				closest_so_far = record.t
+			}
+		}
+
+		axis := 0
+		if next_crossing[1] < next_crossing[axis] {
+			axis = 1
+		}
+		if next_crossing[2] < next_crossing[axis] {
+			axis = 2
+		}
+
+		// Anything in later cells is further away than what we already have, or past the end of the grid.
+		if closest_so_far <= next_crossing[axis] || t1 < next_crossing[axis] {
+			break
+		}
+
+		cell[axis] += step[axis]
+		if cell[axis] == out[axis] {
+			break
+		}
+		next_crossing[axis] += delta[axis]
+	}
+
+	return anything
+}
+
+func (grid *Grid) bounding_box() (bounds *AABB) {
+	return &grid.bounds
+}
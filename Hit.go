@@ -14,12 +14,17 @@ type Hittable interface {
 
 // Struct to store the details of a ray hitting a surface
 type Hit struct {
-	point      Vec3
-	normal     Vec3
-	t          float64
-	u, v       float64 // surface coordinates of the ray-object hit point.
-	front_face bool    // Hack way to check front_face or not Dot(&in, &n) < 0
-	material   *Material
+	point            Vec3
+	normal           Vec3 // Shading normal, may be interpolated across the surface
+	geometric_normal Vec3 // Normal of the actual surface, facing the same side as normal
+	t                float64
+	u, v             float64 // surface coordinates of the ray-object hit point.
+	dpdu, dpdv       Vec3    // How the point moves with u and v, zero if the surface doesn't say
+	color            Vec3    // Vertex colour interpolated across a mesh
+	colored          bool    // Whether color is set
+	front_face       bool    // Hack way to check front_face or not Dot(&in, &n) < 0
+	material         *Material
+	unsampled        bool // Hit through something the light tree can't sample lights behind, so emission is always counted
 }
 
 // Sets the hit record normal vector.
@@ -31,6 +36,20 @@ func (record *Hit) set_face_normal(ray *Ray, outward_normal Vec3) {
 	} else {
 		record.normal = (*outward_normal.Negate())
 	}
+	record.geometric_normal = record.normal
+	record.dpdu, record.dpdv = Vec3{}, Vec3{}
+	record.colored = false
+	record.unsampled = false
+}
+
+// Sets the hit record normal to an interpolated shading normal, keeping front_face and the geometric normal from the surface itself.
+// NOTE: both normals are assumed to have unit length.
+func (record *Hit) set_shading_normal(ray *Ray, outward_normal, shading_normal Vec3) {
+	record.set_face_normal(ray, outward_normal)
+	if Dot(&shading_normal, &record.geometric_normal) < 0 {
+		shading_normal = *shading_normal.Negate()
+	}
+	record.normal = shading_normal
 }
 
 type Hit_List struct {
@@ -0,0 +1,36 @@
+package main
+
+// A placed copy of a shared object, e.g. one tree of a forest.
+// Every instance of a mesh points at the same bottom-level BVH, so memory grows with the unique geometry rather than the number of copies.
+// It is a Transform that can also replace the object's materials.
+type Instance struct {
+	Transform
+	material *Material
+}
+
+// Place object in the world with the given object to world matrix.
+// A non nil material replaces the materials of the object for this copy only.
+func NewInstance(object Hittable, matrix Mat4, material *Material) *Instance {
+	return &Instance{*NewTransform(object, matrix), material}
+}
+
+func (inst *Instance) hit(ray *Ray, ray_tmin float64, ray_tmax float64, record *Hit) (ok bool) {
+	if !inst.Transform.hit(ray, ray_tmin, ray_tmax, record) {
+		return false
+	}
+	if inst.material != nil {
+		// The light tree samples the object's own materials, not the replacement.
+		record.material = inst.material
+		record.unsampled = true
+	}
+	return true
+}
+
+// Build the top-level BVH over a set of instances, each of which carries its own bottom-level structure.
+func NewInstanceBVH(instances ...*Instance) *FlatBVH {
+	objects := make([]Hittable, len(instances))
+	for i, inst := range instances {
+		objects[i] = inst
+	}
+	return NewSAHBVH(objects, 1, 1, 4).Flatten()
+}
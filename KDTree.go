@@ -0,0 +1,236 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// kd-tree accelerator built with the surface area heuristic.
+// Space is split by axis aligned planes, so objects straddling a plane are listed on both sides.
+type KDTree struct {
+	nodes   []kd_node
+	objects []Hittable
+	indices []int // Objects of every leaf, each leaf's indices are contiguous
+	bounds  AABB
+}
+
+type kd_node struct {
+	split  float64 // Position of the splitting plane
+	axis   int     // Axis of the splitting plane, or kd_leaf
+	above  int     // Index of the child above the plane, the child below directly follows its parent
+	offset int     // Leaves: index of the first object in indices
+	count  int     // Leaves: number of objects
+}
+
+const kd_leaf = 3
+
+// Costs of stepping through a node and intersecting an object, and how much cheaper a split with an empty side is.
+const (
+	kd_traversal_cost = 1.0
+	kd_intersect_cost = 80.0
+	kd_empty_bonus    = 0.5
+	kd_max_leaf       = 1
+)
+
+// An object's bounding box starting or ending along an axis.
+type kd_edge struct {
+	t     float64
+	index int
+	start bool
+}
+
+func NewKDTree(objects []Hittable) *KDTree {
+	tree := KDTree{objects: objects, bounds: *NewEmptyAABB()}
+	indices := make([]int, len(objects))
+	for i, object := range objects {
+		tree.bounds.IMerge(object.bounding_box())
+		indices[i] = i
+	}
+
+	if len(objects) > 0 {
+		max_depth := int(math.Round(8 + 1.3*math.Log2(float64(len(objects)))))
+		tree.build(tree.bounds, indices, max_depth, 0)
+	}
+	return &tree
+}
+
+// Append the subtree over the given objects in depth first order.
+// bad_refines counts the splits on the way down that cost more than not splitting.
+func (tree *KDTree) build(bounds AABB, indices []int, depth, bad_refines int) {
+	index := len(tree.nodes)
+	tree.nodes = append(tree.nodes, kd_node{})
+
+	if len(indices) <= kd_max_leaf || depth == 0 {
+		tree.make_leaf(index, indices)
+		return
+	}
+
+	extent := bounds.maxVec.Sub(&bounds.minVec)
+	total_area := bounds.surface_area()
+	leaf_cost := kd_intersect_cost * float64(len(indices))
+
+	// Try the longest axis first, and the others only if it has no usable split.
+	axis := 0
+	if extent[1] > extent[axis] {
+		axis = 1
+	}
+	if extent[2] > extent[axis] {
+		axis = 2
+	}
+
+	best_axis, best_offset, best_cost := -1, -1, math.Inf(1)
+	var edges []kd_edge
+	for retries := 0; retries < 3 && best_axis == -1; retries++ {
+		edges = edges[:0]
+		for _, i := range indices {
+			box := tree.objects[i].bounding_box()
+			edges = append(edges, kd_edge{box.minVec[axis], i, true}, kd_edge{box.maxVec[axis], i, false})
+		}
+		sort.Slice(edges, func(a, b int) bool {
+			if edges[a].t == edges[b].t {
+				return edges[a].start && !edges[b].start
+			}
+			return edges[a].t < edges[b].t
+		})
+
+		// Sweep the candidate planes, tracking how many objects are on each side.
+		below, above := 0, len(indices)
+		other0, other1 := (axis+1)%3, (axis+2)%3
+		for i, edge := range edges {
+			if !edge.start {
+				above--
+			}
+			if edge.t > bounds.minVec[axis] && edge.t < bounds.maxVec[axis] && total_area > 0 {
+				below_area := 2 * (extent[other0]*extent[other1] + (edge.t-bounds.minVec[axis])*(extent[other0]+extent[other1]))
+				above_area := 2 * (extent[other0]*extent[other1] + (bounds.maxVec[axis]-edge.t)*(extent[other0]+extent[other1]))
+
+				bonus := 0.0
+				if below == 0 || above == 0 {
+					bonus = kd_empty_bonus
+				}
+				cost := kd_traversal_cost + kd_intersect_cost*(1-bonus)*(below_area*float64(below)+above_area*float64(above))/total_area
+				if cost < best_cost {
+					best_axis, best_offset, best_cost = axis, i, cost
+				}
+			}
+			if edge.start {
+				below++
+			}
+		}
+
+		if best_axis == -1 {
+			axis = (axis + 1) % 3
+		}
+	}
+
+	if best_cost > leaf_cost {
+		bad_refines++
+	}
+	if best_axis == -1 || bad_refines == 3 || (best_cost > 4*leaf_cost && len(indices) < 16) {
+		tree.make_leaf(index, indices)
+		return
+	}
+
+	// Objects starting before the plane go below it, those ending after it go above. Some go on both sides.
+	var below_indices, above_indices []int
+	for _, edge := range edges[:best_offset] {
+		if edge.start {
+			below_indices = append(below_indices, edge.index)
+		}
+	}
+	for _, edge := range edges[best_offset+1:] {
+		if !edge.start {
+			above_indices = append(above_indices, edge.index)
+		}
+	}
+
+	split := edges[best_offset].t
+	below_bounds, above_bounds := bounds, bounds
+	below_bounds.maxVec[best_axis] = split
+	above_bounds.minVec[best_axis] = split
+
+	tree.nodes[index].axis = best_axis
+	tree.nodes[index].split = split
+	tree.build(below_bounds, below_indices, depth-1, bad_refines)
+	tree.nodes[index].above = len(tree.nodes)
+	tree.build(above_bounds, above_indices, depth-1, bad_refines)
+}
+
+func (tree *KDTree) make_leaf(index int, indices []int) {
+	tree.nodes[index] = kd_node{axis: kd_leaf, offset: len(tree.indices), count: len(indices)}
+	tree.indices = append(tree.indices, indices...)
+}
+
+// A node still to visit along with the part of the ray inside it.
+type kd_todo struct {
+	node       int
+	tmin, tmax float64
+}
+
+func (tree *KDTree) hit(ray *Ray, ray_tmin float64, ray_tmax float64, record *Hit) (ok bool) {
+	if len(tree.nodes) == 0 {
+		return false
+	}
+
+	tmin, tmax, inside := tree.bounds.clip(ray, ray_tmin, ray_tmax)
+	if !inside {
+		return false
+	}
+
+	inv_dir := Vec3{1 / ray.direction[0], 1 / ray.direction[1], 1 / ray.direction[2]}
+
+	var buffer [64]kd_todo
+	todo := buffer[:0]
+
+	closest_so_far := ray_tmax
+	anything := false
+	current := 0
+	for {
+		// Everything left is further away than the closest hit.
+		if closest_so_far < tmin {
+			break
+		}
+
+		node := &tree.nodes[current]
+		if node.axis != kd_leaf {
+			// Visit the child on the same side as the ray origin first.
+			origin := ray.origin[node.axis]
+			t_plane := (node.split - origin) * inv_dir[node.axis]
+			first, second := current+1, node.above
+			if origin > node.split || (origin == node.split && ray.direction[node.axis] > 0) {
+				first, second = second, first
+			}
+
+			if t_plane > tmax || t_plane <= 0 {
+				current = first
+			} else if t_plane < tmin {
+				current = second
+			} else {
+				todo = append(todo, kd_todo{second, t_plane, tmax})
+				current = first
+				tmax = t_plane
+			}
+			continue
+		}
+
+		for _, i := range tree.indices[node.offset : node.offset+node.count] {
+			if tree.objects[i].hit(ray, ray_tmin, closest_so_far, record) {
+				anything = true
+				closest_so_far = record.t
+			}
+		}
+
+		if len(todo) == 0 {
+			break
+		}
+		next := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		current, tmin, tmax = next.node, next.tmin, next.tmax
+	}
+
+	return anything
+}
+
+func (tree *KDTree) bounding_box() (bounds *AABB) {
+	return &tree.bounds
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+)
+
+// An emitter that can be sampled directly from a shading point.
+type Light interface {
+
+	// Samples a direction from origin towards the light at the given time, returning the radiance emitted back along it,
+	// the distance to the light and the pdf of the direction with respect to solid angle.
+	// Lights at a single point or from a single direction can't have a pdf, they give a pdf of 1 and the light arriving at origin instead.
+	sample_light(origin *Vec3, time float64) (direction Vec3, distance float64, emission Vec3, pdf float64)
+
+	// Spatial, power and orientation bounds of the light, used to build the light tree.
+	light_bounds() LightBounds
+}
+
+// Bounds over a group of emitters.
+// The normals of every emitter lie within theta_o of w, and each one emits up to theta_e away from its normal.
+type LightBounds struct {
+	bbox        AABB
+	w           Vec3    // Central direction of the cone of normals
+	phi         float64 // Total emitted power
+	cos_theta_o float64 // Spread of the normals around w
+	cos_theta_e float64 // Spread of the emission around each normal
+	two_sided   bool
+	infinite    bool // Lights infinitely far away, which are kept apart from the tree
+}
+
+// Merge two light bounds, widening the normal cone to contain both.
+func MergeLightBounds(a, b LightBounds) LightBounds {
+	if a.phi == 0 {
+		return b
+	} else if b.phi == 0 {
+		return a
+	}
+
+	w, cos_theta_o := merge_cone(a.w, a.cos_theta_o, b.w, b.cos_theta_o)
+
+	return LightBounds{
+		bbox:        *MergeAABB(a.bbox, b.bbox),
+		w:           w,
+		phi:         a.phi + b.phi,
+		cos_theta_o: cos_theta_o,
+		cos_theta_e: math.Min(a.cos_theta_e, b.cos_theta_e),
+		two_sided:   a.two_sided || b.two_sided,
+	}
+}
+
+// Find the smallest cone of directions that contains both cones.
+func merge_cone(wa Vec3, cos_a float64, wb Vec3, cos_b float64) (w Vec3, cos_theta float64) {
+	theta_a, theta_b := safe_acos(cos_a), safe_acos(cos_b)
+	theta_d := safe_acos(Dot(&wa, &wb))
+
+	// One cone already contains the other
+	if math.Min(theta_d+theta_b, math.Pi) <= theta_a {
+		return wa, cos_a
+	}
+	if math.Min(theta_d+theta_a, math.Pi) <= theta_b {
+		return wb, cos_b
+	}
+
+	theta_o := (theta_a + theta_d + theta_b) / 2
+	if theta_o >= math.Pi {
+		return wa, -1
+	}
+
+	// Rotate wa towards wb so the new cone just touches both old cones.
+	wr := Cross(&wa, &wb)
+	if wr.Length_Squared() == 0 {
+		return wa, -1
+	}
+	return *wa.RotateAxis(wr.Unit(), theta_o-theta_a), math.Cos(theta_o)
+}
+
+// Estimate how much light from these bounds reaches point, a surface with the given normal.
+// A zero normal ignores the receiving surface's orientation (e.g. for participating media).
+func (bounds *LightBounds) importance(point, normal *Vec3) float64 {
+	center := bounds.bbox.center()
+	diagonal := bounds.bbox.maxVec.Sub(&bounds.bbox.minVec)
+
+	// Clamp the distance so points inside the bounds don't get an unbounded estimate.
+	d2 := point.Sub(&center).Length_Squared()
+	d2 = math.Max(d2, diagonal.Magnitude()/2)
+
+	wi := point.Sub(&center)
+	if wi.Length_Squared() == 0 {
+		wi = NewVec3(0, 0, 1)
+	}
+	wi = wi.Unit()
+
+	cos_theta_w := Dot(&bounds.w, wi)
+	if bounds.two_sided {
+		cos_theta_w = math.Abs(cos_theta_w)
+	}
+	sin_theta_w := safe_sqrt(1 - cos_theta_w*cos_theta_w)
+
+	// Angle subtended by the bounds as seen from point.
+	cos_theta_b := bounding_cone_cos(&bounds.bbox, point)
+	sin_theta_b := safe_sqrt(1 - cos_theta_b*cos_theta_b)
+
+	// cos(max(0, theta_w - theta_o - theta_b)) worked out with angle difference identities
+	sin_theta_o := safe_sqrt(1 - bounds.cos_theta_o*bounds.cos_theta_o)
+	cos_theta_x := cos_sub_clamped(sin_theta_w, cos_theta_w, sin_theta_o, bounds.cos_theta_o)
+	sin_theta_x := sin_sub_clamped(sin_theta_w, cos_theta_w, sin_theta_o, bounds.cos_theta_o)
+	cos_theta_p := cos_sub_clamped(sin_theta_x, cos_theta_x, sin_theta_b, cos_theta_b)
+	if cos_theta_p <= bounds.cos_theta_e {
+		return 0
+	}
+
+	importance := bounds.phi * cos_theta_p / d2
+
+	// Account for the incident angle at the receiving surface.
+	if normal[0] != 0 || normal[1] != 0 || normal[2] != 0 {
+		cos_theta_i := math.Abs(Dot(wi, normal))
+		sin_theta_i := safe_sqrt(1 - cos_theta_i*cos_theta_i)
+		importance *= cos_sub_clamped(sin_theta_i, cos_theta_i, sin_theta_b, cos_theta_b)
+	}
+
+	return math.Max(importance, 0)
+}
+
+// Cosine of the half angle of the cone around the direction to the bounding sphere of bbox, as seen from point.
+// Returns -1 if point is inside the sphere, as every direction is then covered.
+func bounding_cone_cos(bbox *AABB, point *Vec3) float64 {
+	center := bbox.center()
+	radius2 := bbox.maxVec.Sub(&center).Length_Squared()
+	d2 := point.Sub(&center).Length_Squared()
+	if d2 < radius2 {
+		return -1
+	}
+	return safe_sqrt(1 - radius2/d2)
+}
+
+// cos(max(0, a - b)) given the sine and cosine of a and b.
+func cos_sub_clamped(sin_a, cos_a, sin_b, cos_b float64) float64 {
+	if cos_a > cos_b {
+		return 1
+	}
+	return cos_a*cos_b + sin_a*sin_b
+}
+
+// sin(max(0, a - b)) given the sine and cosine of a and b.
+func sin_sub_clamped(sin_a, cos_a, sin_b, cos_b float64) float64 {
+	if cos_a > cos_b {
+		return 0
+	}
+	return sin_a*cos_b - cos_a*sin_b
+}
+
+func safe_sqrt(x float64) float64 {
+	return math.Sqrt(math.Max(0, x))
+}
+
+func safe_acos(x float64) float64 {
+	return math.Acos(math.Max(-1, math.Min(1, x)))
+}
+
+// Collects every emissive object that can be sampled as a light, looking inside lists, accelerators and transforms.
+// Objects behind animated transforms or instances replacing their materials are skipped, and their hits are marked
+// unsampled so their emission is picked up when rays hit them instead.
+func Emitters(objects ...Hittable) []Light {
+	var lights []Light
+	for _, object := range objects {
+		switch obj := object.(type) {
+		case *Hit_List:
+			lights = append(lights, Emitters(obj.list...)...)
+		case *BVH:
+			lights = append(lights, Emitters(*obj.left)...)
+			if obj.right != nil && obj.right != obj.left {
+				lights = append(lights, Emitters(*obj.right)...)
+			}
+		case *FlatBVH:
+			lights = append(lights, Emitters(obj.objects...)...)
+		case *KDTree:
+			lights = append(lights, Emitters(obj.objects...)...)
+		case *Grid:
+			lights = append(lights, Emitters(obj.unique_objects()...)...)
+		case *Transform:
+			lights = append(lights, transform_lights(Emitters(obj.object), obj)...)
+		case *Instance:
+			if obj.material == nil {
+				lights = append(lights, transform_lights(Emitters(obj.object), &obj.Transform)...)
+			}
+		case Light:
+			if obj.light_bounds().phi > 0 {
+				lights = append(lights, obj)
+			}
+		}
+	}
+	return lights
+}
+
+// Light Tree, a bounding hierarchy over emitters used to pick a light in proportion to its estimated contribution.
+type LightTree struct {
+	root     *light_node
+	infinite []Light // Picked uniformly, as there are no bounds to compare them by
+}
+
+type light_node struct {
+	bounds      LightBounds
+	left, right *light_node
+	light       Light // Only set for leaves
+}
+
+// Number of buckets the centroid bounds are split into when looking for the best split.
+const light_tree_buckets = 12
+
+func NewLightTree(lights ...Light) *LightTree {
+	var tree LightTree
+	nodes := make([]*light_node, 0, len(lights))
+	for _, light := range lights {
+		bounds := light.light_bounds()
+		// Lights that emit nothing can never be picked.
+		if bounds.phi <= 0 {
+			continue
+		}
+		if bounds.infinite {
+			tree.infinite = append(tree.infinite, light)
+		} else {
+			nodes = append(nodes, &light_node{bounds: bounds, light: light})
+		}
+	}
+
+	if len(nodes) > 0 {
+		tree.root = build_light_tree(nodes)
+	}
+	return &tree
+}
+
+func build_light_tree(leaves []*light_node) *light_node {
+	if len(leaves) == 1 {
+		return leaves[0]
+	}
+
+	var node light_node
+	centroids := NewEmptyAABB()
+	for _, leaf := range leaves {
+		node.bounds = MergeLightBounds(node.bounds, leaf.bounds)
+		c := leaf.bounds.bbox.center()
+		centroids = MergeAABB(*centroids, AABB{c, c})
+	}
+
+	// Find the bucket boundary with the lowest estimated cost across all three axes.
+	best_axis, best_split, best_cost := -1, 0, math.Inf(1)
+	extent := node.bounds.bbox.maxVec.Sub(&node.bounds.bbox.minVec)
+	max_extent := math.Max(extent[0], math.Max(extent[1], extent[2]))
+	for axis := 0; axis < 3; axis++ {
+		lo, hi := centroids.minVec[axis], centroids.maxVec[axis]
+		if hi-lo <= 0 || extent[axis] <= 0 {
+			continue
+		}
+
+		var buckets [light_tree_buckets]LightBounds
+		for _, leaf := range leaves {
+			b := light_bucket(leaf, axis, lo, hi)
+			buckets[b] = MergeLightBounds(buckets[b], leaf.bounds)
+		}
+
+		// Favour splitting along the longest axis of the bounds.
+		kr := max_extent / extent[axis]
+		for split := 0; split < light_tree_buckets-1; split++ {
+			var below, above LightBounds
+			for i := 0; i <= split; i++ {
+				below = MergeLightBounds(below, buckets[i])
+			}
+			for i := split + 1; i < light_tree_buckets; i++ {
+				above = MergeLightBounds(above, buckets[i])
+			}
+			if below.phi == 0 || above.phi == 0 {
+				continue
+			}
+			cost := kr * (light_bounds_cost(&below) + light_bounds_cost(&above))
+			if cost < best_cost {
+				best_axis, best_split, best_cost = axis, split, cost
+			}
+		}
+	}
+
+	mid := len(leaves) / 2
+	if best_axis >= 0 {
+		lo, hi := centroids.minVec[best_axis], centroids.maxVec[best_axis]
+		sort.SliceStable(leaves, func(a, b int) bool {
+			return light_bucket(leaves[a], best_axis, lo, hi) < light_bucket(leaves[b], best_axis, lo, hi)
+		})
+		mid = sort.Search(len(leaves), func(i int) bool {
+			return light_bucket(leaves[i], best_axis, lo, hi) > best_split
+		})
+		if mid == 0 || mid == len(leaves) {
+			mid = len(leaves) / 2
+		}
+	}
+
+	node.left = build_light_tree(leaves[:mid])
+	node.right = build_light_tree(leaves[mid:])
+	return &node
+}
+
+// Which bucket the centroid of a leaf falls in along axis.
+func light_bucket(leaf *light_node, axis int, lo, hi float64) int {
+	c := leaf.bounds.bbox.center()
+	b := int(light_tree_buckets * (c[axis] - lo) / (hi - lo))
+	if b >= light_tree_buckets {
+		b = light_tree_buckets - 1
+	}
+	if b < 0 {
+		b = 0
+	}
+	return b
+}
+
+// Cost of a group of lights: power times the spatial and directional extent it spreads that power over.
+func light_bounds_cost(bounds *LightBounds) float64 {
+	if bounds.phi == 0 {
+		return 0
+	}
+	theta_o := safe_acos(bounds.cos_theta_o)
+	theta_e := safe_acos(bounds.cos_theta_e)
+	theta_w := math.Min(theta_o+theta_e, math.Pi)
+	sin_theta_o := safe_sqrt(1 - bounds.cos_theta_o*bounds.cos_theta_o)
+	m_omega := 2*math.Pi*(1-bounds.cos_theta_o) +
+		math.Pi/2*(2*theta_w*sin_theta_o-math.Cos(theta_o-2*theta_w)-2*theta_o*sin_theta_o+bounds.cos_theta_o)
+	return bounds.phi * m_omega * bounds.bbox.surface_area()
+}
+
+// Pick a light for a shading point with the given normal, returning it with the probability it was picked.
+// Returns a nil light if none of them can reach the point.
+func (tree *LightTree) sample(point, normal *Vec3) (light Light, pmf float64) {
+	// The tree counts as one more choice alongside the infinite lights.
+	pmf = 1
+	if len(tree.infinite) > 0 {
+		choices := len(tree.infinite)
+		if tree.root != nil {
+			choices++
+		}
+		if pick := rand.IntN(choices); pick < len(tree.infinite) {
+			return tree.infinite[pick], 1 / float64(choices)
+		}
+		pmf = 1 - float64(len(tree.infinite))/float64(choices)
+	}
+
+	if tree.root == nil {
+		return nil, 0
+	}
+
+	node := tree.root
+	for node.light == nil {
+		left := node.left.bounds.importance(point, normal)
+		right := node.right.bounds.importance(point, normal)
+		if left == 0 && right == 0 {
+			return nil, 0
+		}
+
+		p_left := left / (left + right)
+		if rand.Float64() < p_left {
+			node = node.left
+			pmf *= p_left
+		} else {
+			node = node.right
+			pmf *= 1 - p_left
+		}
+	}
+
+	return node.light, pmf
+}
@@ -0,0 +1,236 @@
+package main
+
+import "math"
+
+// 4x4 Matrix in row major order, used for affine transforms of points and vectors.
+type Mat4 [4][4]float64
+
+// Identity matrix
+func IdentityMat4() Mat4 {
+	return Mat4{
+		{1, 0, 0, 0},
+		{0, 1, 0, 0},
+		{0, 0, 1, 0},
+		{0, 0, 0, 1},
+	}
+}
+
+// Matrix that moves points by offset
+func TranslationMat4(offset *Vec3) Mat4 {
+	return Mat4{
+		{1, 0, 0, offset[0]},
+		{0, 1, 0, offset[1]},
+		{0, 0, 1, offset[2]},
+		{0, 0, 0, 1},
+	}
+}
+
+// Matrix that scales each axis by the given factor
+func ScalingMat4(x, y, z float64) Mat4 {
+	return Mat4{
+		{x, 0, 0, 0},
+		{0, y, 0, 0},
+		{0, 0, z, 0},
+		{0, 0, 0, 1},
+	}
+}
+
+// Shear matrix, x_y is how far x moves per unit of y and so on.
+func ShearMat4(x_y, x_z, y_x, y_z, z_x, z_y float64) Mat4 {
+	return Mat4{
+		{1, x_y, x_z, 0},
+		{y_x, 1, y_z, 0},
+		{z_x, z_y, 1, 0},
+		{0, 0, 0, 1},
+	}
+}
+
+// Rotation matrix from yaw (alpha, about z), pitch (beta, about y) and roll (gamma, about x) in degrees, applied roll first.
+func RotationMat4(alpha, beta, gamma float64) Mat4 {
+	a, b, g := alpha*math.Pi/180, beta*math.Pi/180, gamma*math.Pi/180
+	ca, sa := math.Cos(a), math.Sin(a)
+	cb, sb := math.Cos(b), math.Sin(b)
+	cg, sg := math.Cos(g), math.Sin(g)
+	return Mat4{
+		{ca * cb, ca*sb*sg - sa*cg, ca*sb*cg + sa*sg, 0},
+		{sa * cb, sa*sb*sg + ca*cg, sa*sb*cg - ca*sg, 0},
+		{-sb, cb * sg, cb * cg, 0},
+		{0, 0, 0, 1},
+	}
+}
+
+// Rotation matrix turning angle degrees anticlockwise about axis. A zero axis gives no rotation.
+func AxisAngleMat4(axis *Vec3, angle float64) Mat4 {
+	if axis.near_zero() {
+		return IdentityMat4()
+	}
+	k := axis.Unit()
+	theta := angle * math.Pi / 180
+	matrix := IdentityMat4()
+	for column, basis := range []Vec3{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}} {
+		rotated := basis.RotateAxis(k, theta)
+		for row := 0; row < 3; row++ {
+			matrix[row][column] = rotated[row]
+		}
+	}
+	return matrix
+}
+
+// Rotation matrix from the unit quaternion with vector part x, y, z and scalar part w.
+func QuaternionMat4(x, y, z, w float64) Mat4 {
+	return Mat4{
+		{1 - 2*(y*y+z*z), 2 * (x*y - z*w), 2 * (x*z + y*w), 0},
+		{2 * (x*y + z*w), 1 - 2*(x*x+z*z), 2 * (y*z - x*w), 0},
+		{2 * (x*z - y*w), 2 * (y*z + x*w), 1 - 2*(x*x+y*y), 0},
+		{0, 0, 0, 1},
+	}
+}
+
+// Matrix product m1 * m2, which applies m2 first and then m1.
+func (m1 *Mat4) Mul(m2 *Mat4) Mat4 {
+	var result Mat4
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			for k := 0; k < 4; k++ {
+				result[i][j] += m1[i][k] * m2[k][j]
+			}
+		}
+	}
+	return result
+}
+
+// Determinant of the upper 3x3 part, negative when the transform mirrors things.
+func (m1 *Mat4) determinant3() float64 {
+	return m1[0][0]*(m1[1][1]*m1[2][2]-m1[1][2]*m1[2][1]) -
+		m1[0][1]*(m1[1][0]*m1[2][2]-m1[1][2]*m1[2][0]) +
+		m1[0][2]*(m1[1][0]*m1[2][1]-m1[1][1]*m1[2][0])
+}
+
+// Transpose of the matrix
+func (m1 *Mat4) Transpose() Mat4 {
+	var result Mat4
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			result[i][j] = m1[j][i]
+		}
+	}
+	return result
+}
+
+// Inverse of the matrix by Gauss-Jordan elimination with partial pivoting.
+// ok is false if the matrix is singular.
+func (m1 *Mat4) Inverse() (inverse Mat4, ok bool) {
+	m := *m1
+	inverse = IdentityMat4()
+
+	for col := 0; col < 4; col++ {
+		// Pick the largest remaining entry in this column as the pivot to keep the elimination stable.
+		pivot := col
+		for row := col + 1; row < 4; row++ {
+			if math.Abs(m[row][col]) > math.Abs(m[pivot][col]) {
+				pivot = row
+			}
+		}
+		// Written so NaN pivots count as singular too.
+		if !(math.Abs(m[pivot][col]) >= 1e-12) {
+			return IdentityMat4(), false
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		inverse[col], inverse[pivot] = inverse[pivot], inverse[col]
+
+		scale := 1 / m[col][col]
+		for j := 0; j < 4; j++ {
+			m[col][j] *= scale
+			inverse[col][j] *= scale
+		}
+
+		for row := 0; row < 4; row++ {
+			if row == col {
+				continue
+			}
+			factor := m[row][col]
+			for j := 0; j < 4; j++ {
+				m[row][j] -= factor * m[col][j]
+				inverse[row][j] -= factor * inverse[col][j]
+			}
+		}
+	}
+
+	return inverse, true
+}
+
+// Transform a point, which is affected by translation.
+func (m1 *Mat4) TransformPoint(v1 *Vec3) *Vec3 {
+	return &Vec3{
+		m1[0][0]*v1[0] + m1[0][1]*v1[1] + m1[0][2]*v1[2] + m1[0][3],
+		m1[1][0]*v1[0] + m1[1][1]*v1[1] + m1[1][2]*v1[2] + m1[1][3],
+		m1[2][0]*v1[0] + m1[2][1]*v1[1] + m1[2][2]*v1[2] + m1[2][3],
+	}
+}
+
+// Transform a direction, which ignores translation.
+func (m1 *Mat4) TransformVector(v1 *Vec3) *Vec3 {
+	return &Vec3{
+		m1[0][0]*v1[0] + m1[0][1]*v1[1] + m1[0][2]*v1[2],
+		m1[1][0]*v1[0] + m1[1][1]*v1[1] + m1[1][2]*v1[2],
+		m1[2][0]*v1[0] + m1[2][1]*v1[1] + m1[2][2]*v1[2],
+	}
+}
+
+// Transform a normal by the transpose of this matrix. Call it on the inverse of the transform applied to the surface
+// so normals stay perpendicular under non-uniform scaling and shearing.
+func (m1 *Mat4) TransformNormal(v1 *Vec3) *Vec3 {
+	return &Vec3{
+		m1[0][0]*v1[0] + m1[1][0]*v1[1] + m1[2][0]*v1[2],
+		m1[0][1]*v1[0] + m1[1][1]*v1[1] + m1[2][1]*v1[2],
+		m1[0][2]*v1[0] + m1[1][2]*v1[1] + m1[2][2]*v1[2],
+	}
+}
+
+// Bounding box of the eight corners of bbox after transforming them.
+func (m1 *Mat4) TransformAABB(bbox *AABB) *AABB {
+	min := NewVec3(math.Inf(1), math.Inf(1), math.Inf(1))
+	max := NewVec3(math.Inf(-1), math.Inf(-1), math.Inf(-1))
+
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2; j++ {
+			for k := 0; k < 2; k++ {
+				x := float64(i)*bbox.maxVec[0] + (1-float64(i))*bbox.minVec[0]
+				y := float64(j)*bbox.maxVec[1] + (1-float64(j))*bbox.minVec[1]
+				z := float64(k)*bbox.maxVec[2] + (1-float64(k))*bbox.minVec[2]
+
+				corner := m1.TransformPoint(NewVec3(x, y, z))
+				for c := 0; c < 3; c++ {
+					min[c] = math.Min(min[c], corner[c])
+					max[c] = math.Max(max[c], corner[c])
+				}
+			}
+		}
+	}
+
+	return NewAABB(*min, *max)
+}
+
+// Scale factor of a transform that only rotates, translates and scales evenly. ok is false for anything else.
+func similarity_scale(matrix *Mat4) (scale float64, ok bool) {
+	var columns [3]Vec3
+	for column := 0; column < 3; column++ {
+		columns[column] = *NewVec3(matrix[0][column], matrix[1][column], matrix[2][column])
+	}
+	scale = columns[0].Magnitude()
+	if scale == 0 {
+		return 0, false
+	}
+	const tolerance = 1e-6
+	for i := 0; i < 3; i++ {
+		if math.Abs(columns[i].Magnitude()-scale) > tolerance*scale {
+			return 0, false
+		}
+		for j := i + 1; j < 3; j++ {
+			if math.Abs(Dot(&columns[i], &columns[j])) > tolerance*scale*scale {
+				return 0, false
+			}
+		}
+	}
+	return scale, true
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAxisAngleMat4(t *testing.T) {
+	tests := []struct {
+		name   string
+		axis   Vec3
+		angle  float64
+		vector Vec3
+		want   Vec3
+	}{
+		{"zero axis gives no rotation", Vec3{0, 0, 0}, 90, Vec3{1, 2, 3}, Vec3{1, 2, 3}},
+		{"quarter turn about z", Vec3{0, 0, 1}, 90, Vec3{1, 0, 0}, Vec3{0, 1, 0}},
+		{"axis length doesn't matter", Vec3{0, 0, 5}, 90, Vec3{1, 0, 0}, Vec3{0, 1, 0}},
+		{"half turn about x", Vec3{1, 0, 0}, 180, Vec3{0, 1, 0}, Vec3{0, -1, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matrix := AxisAngleMat4(&test.axis, test.angle)
+			got := matrix.TransformVector(&test.vector)
+			for i := range got {
+				if math.Abs(got[i]-test.want[i]) > 1e-9 {
+					t.Fatalf("rotated %v to %v, expected %v", test.vector, *got, test.want)
+				}
+			}
+			if _, ok := matrix.Inverse(); !ok {
+				t.Errorf("rotation %v isn't invertible", matrix)
+			}
+		})
+	}
+}
+
+func TestInverseSingular(t *testing.T) {
+	nan := IdentityMat4()
+	nan[1][1] = math.NaN()
+	for _, matrix := range []Mat4{ScalingMat4(1, 0, 1), nan} {
+		if _, ok := matrix.Inverse(); ok {
+			t.Errorf("%v should be singular", matrix)
+		}
+	}
+}
@@ -71,7 +71,9 @@ func (constant *Constant) hit(ray *Ray, ray_tmin float64, ray_tmax float64, reco
 	record.point = ray.At(record.t)
 
 	record.normal = *NewVec3(1, 0, 0) // arbitrary
-	record.front_face = true          // arbitrary
+	record.geometric_normal = record.normal
+	record.front_face = true // arbitrary
+	record.unsampled = false
 	record.material = constant.phase_function
 
 	return true
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// Triangle mesh storing each vertex once, with faces referring to them by index.
+// Much lighter than a Triangle per face, which copies its corners and precomputed values.
+type TriangleMesh struct {
+	positions        []Vec3
+	indices          []int32      // Three position indices per triangle
+	normals          []Vec3       // Vertex normals for smooth shading, empty for flat shading
+	normal_indices   []int32      // Three normal indices per triangle, matching indices corner for corner
+	uvs              [][2]float64 // Texture coordinates, empty to use the barycentric coordinates instead
+	uv_indices       []int32      // Three texture coordinate indices per triangle
+	colors           []Vec3       // Vertex colours, one for each position, empty if there are none
+	material         *Material
+	materials        []*Material // Materials the triangles choose from, empty to use material for all of them
+	material_indices []int32     // Index into materials for each triangle
+	groups           []mesh_group
+	triangles        []MeshTriangle
+	warnings         []error // Problems while loading that were worked around, such as missing textures
+}
+
+// A named run of triangles, e.g. one object of an OBJ file.
+type mesh_group struct {
+	name         string
+	start, count int // Triangles start to start+count-1
+}
+
+// A reference to one triangle of a mesh, so it can be put in a BVH.
+type MeshTriangle struct {
+	mesh  *TriangleMesh
+	index int32 // Which triangle of the mesh, its corners are at indices[3*index:3*index+3]
+}
+
+// Create a mesh from vertex positions and three position indices per triangle.
+func NewTriangleMesh(positions []Vec3, indices []int32, material *Material) *TriangleMesh {
+	mesh := TriangleMesh{
+		positions: positions,
+		indices:   indices,
+		material:  material,
+		triangles: make([]MeshTriangle, len(indices)/3),
+	}
+	for i := range mesh.triangles {
+		mesh.triangles[i] = MeshTriangle{&mesh, int32(i)}
+	}
+	return &mesh
+}
+
+// Number of triangles in the mesh
+func (mesh *TriangleMesh) Len() int {
+	return len(mesh.triangles)
+}
+
+// Shade the mesh smoothly with the given unit normals, three normal indices per triangle.
+func (mesh *TriangleMesh) SetNormals(normals []Vec3, normal_indices []int32) {
+	mesh.normals = normals
+	mesh.normal_indices = normal_indices
+}
+
+// Map textures onto the mesh with the given texture coordinates, three indices per triangle.
+func (mesh *TriangleMesh) SetUVs(uvs [][2]float64, uv_indices []int32) {
+	mesh.uvs = uvs
+	mesh.uv_indices = uv_indices
+}
+
+// Give every vertex a colour, which NewVertexColorTexture shows.
+func (mesh *TriangleMesh) SetColors(colors []Vec3) {
+	mesh.colors = colors
+}
+
+// Give each triangle its own material, material_indices picks one of materials for every triangle.
+func (mesh *TriangleMesh) SetMaterials(materials []*Material, material_indices []int32) {
+	mesh.materials = materials
+	mesh.material_indices = material_indices
+}
+
+// Move the mesh by matrix, e.g. from object to world space. Returns false and leaves the mesh alone if matrix is singular.
+func (mesh *TriangleMesh) Transform(matrix Mat4) bool {
+	inverse, ok := matrix.Inverse()
+	if !ok {
+		return false
+	}
+
+	for i := range mesh.positions {
+		mesh.positions[i] = *matrix.TransformPoint(&mesh.positions[i])
+	}
+	for i := range mesh.normals {
+		mesh.normals[i] = *inverse.TransformNormal(&mesh.normals[i]).Unit()
+	}
+
+	// Mirroring turns the winding inside out, so flip it back. Index slices are often shared, so each is only flipped once.
+	if matrix.determinant3() < 0 {
+		flipped := make(map[*int32]bool)
+		for _, indices := range [][]int32{mesh.indices, mesh.normal_indices, mesh.uv_indices} {
+			if len(indices) == 0 || flipped[&indices[0]] {
+				continue
+			}
+			flipped[&indices[0]] = true
+			for i := 0; i+2 < len(indices); i += 3 {
+				indices[i+1], indices[i+2] = indices[i+2], indices[i+1]
+			}
+		}
+	}
+	return true
+}
+
+// ComputeNormals gives the mesh smooth vertex normals by averaging the normals of the faces around each vertex, weighted by the angle of
+// each face at that vertex. Faces meeting at more than crease_angle degrees keep a hard edge between them.
+func (mesh *TriangleMesh) ComputeNormals(crease_angle float64) {
+	cos_crease := math.Cos(crease_angle * math.Pi / 180)
+
+	// Unit normal of each face and its angle at each corner.
+	face_normals := make([]Vec3, len(mesh.triangles))
+	corner_angles := make([]float64, len(mesh.indices))
+	for i := range mesh.triangles {
+		p0, p1, p2 := mesh.triangles[i].vertices()
+		n := Cross(p1.Sub(p0), p2.Sub(p0))
+		if !n.near_zero() {
+			face_normals[i] = *n.Unit()
+		}
+		corner_angles[3*i] = corner_angle(p0, p1, p2)
+		corner_angles[3*i+1] = corner_angle(p1, p2, p0)
+		corner_angles[3*i+2] = corner_angle(p2, p0, p1)
+	}
+
+	// Corners that share each vertex.
+	corners := make([][]int32, len(mesh.positions))
+	for corner, vertex := range mesh.indices {
+		corners[vertex] = append(corners[vertex], int32(corner))
+	}
+
+	normals := make([]Vec3, 0, len(mesh.positions))
+	normal_indices := make([]int32, len(mesh.indices))
+	for _, shared := range corners {
+		// Corners of the same vertex that end up with the same normal share one entry.
+		first := len(normals)
+		for _, corner := range shared {
+			face := face_normals[corner/3]
+			var sum Vec3
+			for _, other := range shared {
+				other_face := face_normals[other/3]
+				if Dot(&face, &other_face) >= cos_crease {
+					sum.IAdd(other_face.Scale(corner_angles[other]))
+				}
+			}
+			normal := face
+			if !sum.near_zero() {
+				normal = *sum.Unit()
+			}
+
+			index := -1
+			for i := first; i < len(normals); i++ {
+				if normals[i] == normal {
+					index = i
+					break
+				}
+			}
+			if index < 0 {
+				index = len(normals)
+				normals = append(normals, normal)
+			}
+			normal_indices[corner] = int32(index)
+		}
+	}
+
+	mesh.SetNormals(normals, normal_indices)
+}
+
+// Angle at corner a of the triangle a, b, c in radians.
+func corner_angle(a, b, c *Vec3) float64 {
+	ab, ac := b.Sub(a), c.Sub(a)
+	if ab.near_zero() || ac.near_zero() {
+		return 0
+	}
+	return safe_acos(Dot(ab.Unit(), ac.Unit()))
+}
+
+// Triangles returns a Hittable for each triangle, referencing the shared vertices.
+func (mesh *TriangleMesh) Triangles() []Hittable {
+	objects := make([]Hittable, len(mesh.triangles))
+	for i := range mesh.triangles {
+		objects[i] = &mesh.triangles[i]
+	}
+	return objects
+}
+
+// Problems loading the mesh that didn't stop it loading, for the caller to report.
+func (mesh *TriangleMesh) Warnings() []error {
+	return mesh.warnings
+}
+
+// Names of the groups in the mesh, in the order they appear. A name can be given to several groups.
+func (mesh *TriangleMesh) GroupNames() []string {
+	var names []string
+	for _, group := range mesh.groups {
+		names = append(names, group.name)
+	}
+	return names
+}
+
+// Group returns a Hittable for each triangle in the groups with the given name.
+func (mesh *TriangleMesh) Group(name string) []Hittable {
+	var objects []Hittable
+	for _, group := range mesh.groups {
+		if group.name == name {
+			for i := group.start; i < group.start+group.count; i++ {
+				objects = append(objects, &mesh.triangles[i])
+			}
+		}
+	}
+	return objects
+}
+
+// Build a BVH over the triangles of the mesh.
+func (mesh *TriangleMesh) BVH() *FlatBVH {
+	return NewSAHBVH(mesh.Triangles(), 4, 1, 1).Flatten()
+}
+
+// Corners of the triangle
+func (tri *MeshTriangle) vertices() (p0, p1, p2 *Vec3) {
+	i := 3 * tri.index
+	positions, indices := tri.mesh.positions, tri.mesh.indices
+	return &positions[indices[i]], &positions[indices[i+1]], &positions[indices[i+2]]
+}
+
+// Material of the triangle
+func (tri *MeshTriangle) material() *Material {
+	if len(tri.mesh.materials) == 0 {
+		return tri.mesh.material
+	}
+	return tri.mesh.materials[tri.mesh.material_indices[tri.index]]
+}
+
+// Texture coordinates at the point with barycentric coordinates alpha and beta for p1 and p2.
+// Meshes without texture coordinates use alpha and beta themselves, the same as Triangle.
+func (tri *MeshTriangle) uv(alpha, beta float64) (u, v float64) {
+	if len(tri.mesh.uvs) == 0 {
+		return alpha, beta
+	}
+	i, uvs, indices := 3*tri.index, tri.mesh.uvs, tri.mesh.uv_indices
+	uv0, uv1, uv2 := uvs[indices[i]], uvs[indices[i+1]], uvs[indices[i+2]]
+	gamma := 1 - alpha - beta
+	return gamma*uv0[0] + alpha*uv1[0] + beta*uv2[0], gamma*uv0[1] + alpha*uv1[1] + beta*uv2[1]
+}
+
+// Möller-Trumbore intersection.
+func (tri *MeshTriangle) hit(ray *Ray, ray_tmin float64, ray_tmax float64, record *Hit) (ok bool) {
+	p0, p1, p2 := tri.vertices()
+	edge1, edge2 := p1.Sub(p0), p2.Sub(p0)
+
+	pvec := Cross(&ray.direction, edge2)
+	det := Dot(edge1, pvec)
+
+	// No hit if the ray is parallel to the plane.
+	if math.Abs(det) < 1e-12 {
+		return false
+	}
+	inv_det := 1 / det
+
+	tvec := ray.origin.Sub(p0)
+	alpha := Dot(tvec, pvec) * inv_det
+	if alpha < 0 || alpha > 1 {
+		return false
+	}
+
+	qvec := Cross(tvec, edge1)
+	beta := Dot(&ray.direction, qvec) * inv_det
+	if beta < 0 || alpha+beta > 1 {
+		return false
+	}
+
+	t := Dot(edge2, qvec) * inv_det
+	if t < ray_tmin || t > ray_tmax {
+		return false
+	}
+
+	record.u, record.v = tri.uv(alpha, beta)
+	record.t = t
+	record.point = ray.At(t)
+	record.material = tri.material()
+	if len(tri.mesh.normals) > 0 {
+		i, normals, indices := 3*tri.index, tri.mesh.normals, tri.mesh.normal_indices
+		shading := interpolate_normal(&normals[indices[i]], &normals[indices[i+1]], &normals[indices[i+2]], alpha, beta)
+		record.set_shading_normal(ray, *Cross(edge1, edge2).Unit(), shading)
+	} else {
+		record.set_face_normal(ray, *Cross(edge1, edge2).Unit())
+	}
+	record.dpdu, record.dpdv = tri.tangents(edge1, edge2)
+	if len(tri.mesh.colors) > 0 {
+		colors, indices, i := tri.mesh.colors, tri.mesh.indices, 3*tri.index
+		gamma := 1 - alpha - beta
+		record.color = *colors[indices[i]].Scale(gamma).Add(colors[indices[i+1]].Scale(alpha)).Add(colors[indices[i+2]].Scale(beta))
+		record.colored = true
+	}
+	return true
+}
+
+// How the surface moves with the texture coordinates, found by solving edge = dpdu*du + dpdv*dv along both edges.
+func (tri *MeshTriangle) tangents(edge1, edge2 *Vec3) (dpdu, dpdv Vec3) {
+	if len(tri.mesh.uvs) == 0 {
+		return *edge1, *edge2
+	}
+	i, uvs, indices := 3*tri.index, tri.mesh.uvs, tri.mesh.uv_indices
+	uv0, uv1, uv2 := uvs[indices[i]], uvs[indices[i+1]], uvs[indices[i+2]]
+	du1, dv1 := uv1[0]-uv0[0], uv1[1]-uv0[1]
+	du2, dv2 := uv2[0]-uv0[0], uv2[1]-uv0[1]
+	det := du1*dv2 - dv1*du2
+	if math.Abs(det) < 1e-12 {
+		// Degenerate texture coordinates, any frame in the plane will do.
+		return build_onb(Cross(edge1, edge2).Unit())
+	}
+	inv_det := 1 / det
+	dpdu = *edge1.Scale(dv2).Sub(edge2.Scale(dv1)).Scale(inv_det)
+	dpdv = *edge2.Scale(du1).Sub(edge1.Scale(du2)).Scale(inv_det)
+	return dpdu, dpdv
+}
+
+// Computed on every call, as storing it would undo the memory saving.
+func (tri *MeshTriangle) bounding_box() (bounds *AABB) {
+	p0, p1, p2 := tri.vertices()
+	return NewAABB(
+		*NewVec3(math.Min(p0[0], math.Min(p1[0], p2[0])), math.Min(p0[1], math.Min(p1[1], p2[1])), math.Min(p0[2], math.Min(p1[2], p2[2]))),
+		*NewVec3(math.Max(p0[0], math.Max(p1[0], p2[0])), math.Max(p0[1], math.Max(p1[1], p2[1])), math.Max(p0[2], math.Max(p1[2], p2[2]))),
+	)
+}
+
+// Samples a point uniformly over the area of the triangle and converts the pdf to solid angle as seen from origin.
+func (tri *MeshTriangle) sample_light(origin *Vec3, time float64) (direction Vec3, distance float64, emission Vec3, pdf float64) {
+	p0, p1, p2 := tri.vertices()
+	edge1, edge2 := p1.Sub(p0), p2.Sub(p0)
+	n := Cross(edge1, edge2)
+	area := n.Magnitude() / 2
+	if area == 0 {
+		return direction, distance, Vec3{}, 0
+	}
+
+	// Fold samples from the far half of the parallelogram back into the triangle.
+	alpha, beta := rand.Float64(), rand.Float64()
+	if alpha+beta > 1 {
+		alpha, beta = 1-alpha, 1-beta
+	}
+	point := p0.Add(edge1.Scale(alpha)).Add(edge2.Scale(beta))
+
+	to_light := point.Sub(origin)
+	distance_squared := to_light.Length_Squared()
+	distance = math.Sqrt(distance_squared)
+	direction = *to_light.Scale(1 / distance)
+
+	cosine := math.Abs(Dot(&direction, n.Unit()))
+	if cosine < 1e-8 {
+		return direction, distance, Vec3{}, 0
+	}
+
+	u, v := tri.uv(alpha, beta)
+	emission = (*tri.material()).emitted(u, v, point)
+	return direction, distance, emission, distance_squared / (cosine * area)
+}
+
+// Triangles emit from both faces, all along the normal.
+func (tri *MeshTriangle) light_bounds() LightBounds {
+	p0, p1, p2 := tri.vertices()
+	n := Cross(p1.Sub(p0), p2.Sub(p0))
+	area := n.Magnitude() / 2
+	if area == 0 {
+		return LightBounds{}
+	}
+
+	centroid := p0.Add(p1).Add(p2).Scale(1.0 / 3)
+	u, v := tri.uv(1.0/3, 1.0/3)
+	emission := (*tri.material()).emitted(u, v, centroid)
+	return LightBounds{
+		bbox:        *tri.bounding_box(),
+		w:           *n.Unit(),
+		phi:         emission.Luminance() * area * 2,
+		cos_theta_o: 1,
+		cos_theta_e: 0,
+		two_sided:   true,
+	}
+}
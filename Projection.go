@@ -0,0 +1,104 @@
+package main
+
+import "math"
+
+// How the camera turns pixels into rays.
+type Projection int
+
+const (
+	ProjectionPerspective        Projection = iota // Rays spread out from lookfrom through a viewport vfov tall, with optional defocus blur
+	ProjectionOrthographic                         // Parallel rays along the view direction, from a viewport view_height tall through lookfrom
+	ProjectionEquirectangular                      // All the way round, longitude across and latitude down, for 2:1 images
+	ProjectionCubeMap                              // Six square faces side by side: right, left, up, down, front and back
+	ProjectionFisheyeEquidistant                   // Circular fisheye, distance from the centre proportional to the angle off the view direction
+	ProjectionFisheyeEquisolid                     // Circular fisheye preserving solid angle, as used for dome projection
+)
+
+// Switch to an orthographic projection seeing view_height world units from the top of the image to the bottom, for technical
+// and architectural renders where parallel lines stay parallel. Rays start on the plane through lookfrom facing lookat,
+// so nothing behind the camera is seen, and there is no defocus blur.
+func (cam *camera) Orthographic(view_height float64) {
+	cam.projection = ProjectionOrthographic
+	cam.view_height = view_height
+	viewport_width := view_height * (float64(cam.image_width) / float64(cam.image_height))
+
+	// Built from the camera's own u, so a mirrored camera stays mirrored.
+	viewport_u := cam.u.Scale(viewport_width)
+	viewport_v := cam.v.Scale(-view_height)
+	cam.pixel_delta_u = *viewport_u.Scale(1.0 / float64(cam.image_width))
+	cam.pixel_delta_v = *viewport_v.Scale(1.0 / float64(cam.image_height))
+
+	viewport_upper_left := cam.camera_center.Sub(viewport_u.Scale(0.5)).Sub(viewport_v.Scale(0.5))
+	cam.pixel00_loc = *viewport_upper_left.Add((cam.pixel_delta_u.Add(&cam.pixel_delta_v)).Scale(0.5))
+}
+
+// Switch to a 360° equirectangular panorama from lookfrom, with the view direction in the centre of the image
+// and vup at the top. The image should be twice as wide as it is tall.
+func (cam *camera) Equirectangular() {
+	cam.projection = ProjectionEquirectangular
+}
+
+// Switch to a cube map from lookfrom, its six faces side by side in an image six times as wide as it is tall.
+// Each face is a 90° view seen from inside the cube, front along the view direction with vup at the top of it,
+// up and down faces with the front towards the bottom and top of them.
+func (cam *camera) CubeMap() {
+	cam.projection = ProjectionCubeMap
+}
+
+// Switch to a circular fisheye from lookfrom, either ProjectionFisheyeEquidistant or ProjectionFisheyeEquisolid,
+// seeing fov degrees across a circle filling the shorter side of the image. Fields of view up to 360° work.
+func (cam *camera) Fisheye(projection Projection, fov float64) {
+	if projection != ProjectionFisheyeEquisolid {
+		projection = ProjectionFisheyeEquidistant
+	}
+	cam.projection = projection
+	cam.fisheye_fov = fov
+}
+
+// Direction seen x of the way across and y of the way down the image by a panoramic projection.
+// ok is false outside a fisheye's image circle.
+func (cam *camera) panoramic_direction(x, y float64) (direction Vec3, ok bool) {
+	// Worked out with right, up and forward along x, y and z, then turned into the camera's frame.
+	var local Vec3
+	switch cam.projection {
+	case ProjectionEquirectangular:
+		longitude := (x - 0.5) * 2 * math.Pi
+		latitude := (0.5 - y) * math.Pi
+		local = Vec3{math.Cos(latitude) * math.Sin(longitude), math.Sin(latitude), math.Cos(latitude) * math.Cos(longitude)}
+	case ProjectionCubeMap:
+		face := min(int(x*6), 5)
+		a, b := (x*6-float64(face))*2-1, y*2-1 // -1 to 1 across and down the face
+		forward, right, up := cube_faces[face][0], cube_faces[face][1], cube_faces[face][2]
+		local = *forward.Add(right.Scale(a)).Sub(up.Scale(b))
+	case ProjectionFisheyeEquidistant, ProjectionFisheyeEquisolid:
+		// Position in the image circle, with radius 1 at its edge.
+		radius := float64(min(cam.image_width, cam.image_height)) / 2
+		px := (x*float64(cam.image_width) - float64(cam.image_width)/2) / radius
+		py := (float64(cam.image_height)/2 - y*float64(cam.image_height)) / radius
+		r := math.Hypot(px, py)
+		if r > 1 {
+			return Vec3{}, false
+		}
+
+		max_angle := cam.fisheye_fov / 2 * math.Pi / 180
+		theta := r * max_angle
+		if cam.projection == ProjectionFisheyeEquisolid {
+			theta = 2 * math.Asin(r*math.Sin(max_angle/2))
+		}
+		phi := math.Atan2(py, px)
+		local = Vec3{math.Sin(theta) * math.Cos(phi), math.Sin(theta) * math.Sin(phi), math.Cos(theta)}
+	}
+
+	direction = *cam.u.Scale(local[0]).Add(cam.v.Scale(local[1])).Sub(cam.w.Scale(local[2]))
+	return direction, true
+}
+
+// Forward, right and up of each cube map face, in order, with x right, y up and z forward.
+var cube_faces = [6][3]Vec3{
+	{{1, 0, 0}, {0, 0, -1}, {0, 1, 0}},  // Right
+	{{-1, 0, 0}, {0, 0, 1}, {0, 1, 0}},  // Left
+	{{0, 1, 0}, {1, 0, 0}, {0, 0, -1}},  // Up
+	{{0, -1, 0}, {1, 0, 0}, {0, 0, 1}},  // Down
+	{{0, 0, 1}, {1, 0, 0}, {0, 1, 0}},   // Front
+	{{0, 0, -1}, {-1, 0, 0}, {0, 1, 0}}, // Back
+}
@@ -0,0 +1,106 @@
+package main
+
+import "math"
+
+// Lights with no surface, so rays never hit them. They only show up through direct light sampling,
+// so they need to be in the camera's light tree.
+
+// Point Light, shining equally in every direction
+type PointLight struct {
+	position  Vec3
+	intensity Vec3 // Emitted power per unit solid angle
+}
+
+func NewPointLight(position, intensity Vec3) *PointLight {
+	return &PointLight{position, intensity}
+}
+
+func (light *PointLight) sample_light(origin *Vec3, time float64) (direction Vec3, distance float64, emission Vec3, pdf float64) {
+	to_light := light.position.Sub(origin)
+	distance_squared := to_light.Length_Squared()
+	if distance_squared == 0 {
+		return direction, 0, Vec3{}, 0
+	}
+	distance = math.Sqrt(distance_squared)
+	direction = *to_light.Scale(1 / distance)
+	return direction, distance, *light.intensity.Scale(1 / distance_squared), 1
+}
+
+func (light *PointLight) light_bounds() LightBounds {
+	return LightBounds{
+		bbox:        *NewAABB(light.position, light.position),
+		w:           *NewVec3(0, 0, 1),
+		phi:         4 * math.Pi * light.intensity.Luminance(),
+		cos_theta_o: -1, // Every direction
+		cos_theta_e: 0,
+	}
+}
+
+// Spot Light, a point light limited to a cone that fades out between the inner and outer angles
+type SpotLight struct {
+	position  Vec3
+	direction Vec3 // Unit direction the cone points along
+	intensity Vec3
+	cos_inner float64
+	cos_outer float64
+}
+
+// Create a spot light, with the cone angles measured from its axis in degrees.
+func NewSpotLight(position, direction, intensity Vec3, inner_angle, outer_angle float64) *SpotLight {
+	return &SpotLight{
+		position:  position,
+		direction: *direction.Unit(),
+		intensity: intensity,
+		cos_inner: math.Cos(inner_angle * math.Pi / 180),
+		cos_outer: math.Cos(outer_angle * math.Pi / 180),
+	}
+}
+
+func (light *SpotLight) sample_light(origin *Vec3, time float64) (direction Vec3, distance float64, emission Vec3, pdf float64) {
+	to_light := light.position.Sub(origin)
+	distance_squared := to_light.Length_Squared()
+	if distance_squared == 0 {
+		return direction, 0, Vec3{}, 0
+	}
+	distance = math.Sqrt(distance_squared)
+	direction = *to_light.Scale(1 / distance)
+
+	// Smoothstep from the outer edge of the cone to the inner one.
+	cosine := -Dot(&direction, &light.direction)
+	falloff := 1.0
+	if cosine <= light.cos_outer {
+		return direction, distance, Vec3{}, 0
+	} else if cosine < light.cos_inner {
+		t := (cosine - light.cos_outer) / (light.cos_inner - light.cos_outer)
+		falloff = t * t * (3 - 2*t)
+	}
+	return direction, distance, *light.intensity.Scale(falloff / distance_squared), 1
+}
+
+func (light *SpotLight) light_bounds() LightBounds {
+	return LightBounds{
+		bbox:        *NewAABB(light.position, light.position),
+		w:           light.direction,
+		phi:         4 * math.Pi * light.intensity.Luminance(),
+		cos_theta_o: light.cos_inner,
+		cos_theta_e: math.Cos(safe_acos(light.cos_outer) - safe_acos(light.cos_inner)),
+	}
+}
+
+// Directional Light, infinitely far away like the sun
+type DirectionalLight struct {
+	direction  Vec3 // Unit direction the light travels in
+	irradiance Vec3 // Light arriving at a surface facing it
+}
+
+func NewDirectionalLight(direction, irradiance Vec3) *DirectionalLight {
+	return &DirectionalLight{*direction.Unit(), irradiance}
+}
+
+func (light *DirectionalLight) sample_light(origin *Vec3, time float64) (direction Vec3, distance float64, emission Vec3, pdf float64) {
+	return *light.direction.Negate(), math.Inf(1), light.irradiance, 1
+}
+
+func (light *DirectionalLight) light_bounds() LightBounds {
+	return LightBounds{phi: light.irradiance.Luminance(), infinite: true}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"math"
+	"math/rand/v2"
 )
 
 type Quad struct {
@@ -11,6 +12,7 @@ type Quad struct {
 	material *Material
 	normal   Vec3    // The normal
 	D        float64 // Constant D
+	area     float64
 	bbox     AABB
 }
 
@@ -26,6 +28,7 @@ func NewQuad(Q *Vec3, u, v *Vec3, material *Material) *Quad {
 		material: material,
 		normal:   *normal,
 		D:        Dot(normal, Q),
+		area:     n.Magnitude(),
 		// Compute the bounding box of all four vertices.
 		bbox: *MergeAABB(*NewAABB(*Q, *Q.Add(u).Add(v)), *NewAABB(*Q.Add(u), *Q.Add(v))),
 	}
@@ -64,6 +67,7 @@ func (quad *Quad) hit(ray *Ray, ray_tmin float64, ray_tmax float64, record *Hit)
 	record.point = intersection
 	record.material = quad.material
 	record.set_face_normal(ray, quad.normal)
+	record.dpdu, record.dpdv = quad.u, quad.v
 	return true
 }
 
@@ -71,6 +75,38 @@ func (quad *Quad) bounding_box() (bounds *AABB) {
 	return &quad.bbox
 }
 
+// Samples a point uniformly over the area of the quad and converts the pdf to solid angle as seen from origin.
+func (quad *Quad) sample_light(origin *Vec3, time float64) (direction Vec3, distance float64, emission Vec3, pdf float64) {
+	alpha, beta := rand.Float64(), rand.Float64()
+	point := quad.Q.Add(quad.u.Scale(alpha)).Add(quad.v.Scale(beta))
+
+	to_light := point.Sub(origin)
+	distance_squared := to_light.Length_Squared()
+	distance = math.Sqrt(distance_squared)
+	direction = *to_light.Scale(1 / distance)
+
+	cosine := math.Abs(Dot(&direction, &quad.normal))
+	if cosine < 1e-8 {
+		return direction, distance, Vec3{}, 0
+	}
+
+	emission = (*quad.material).emitted(alpha, beta, point)
+	return direction, distance, emission, distance_squared / (cosine * quad.area)
+}
+
+// Quads emit from both faces, all along the normal.
+func (quad *Quad) light_bounds() LightBounds {
+	emission := (*quad.material).emitted(0.5, 0.5, quad.Q.Add(quad.u.Scale(0.5)).Add(quad.v.Scale(0.5)))
+	return LightBounds{
+		bbox:        quad.bbox,
+		w:           quad.normal,
+		phi:         emission.Luminance() * quad.area * 2,
+		cos_theta_o: 1,
+		cos_theta_e: 0,
+		two_sided:   true,
+	}
+}
+
 // Makes 3D box (six sides) that contains the two opposite vertices a & b.
 func NewBox(a, b Vec3, material *Material) *Hit_List {
 	var sides Hit_List
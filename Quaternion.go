@@ -0,0 +1,139 @@
+package main
+
+import "math"
+
+// Unit quaternion x, y, z, w for a rotation, with the axis scaled by sin(angle/2) in x, y, z and cos(angle/2) in w.
+// Unlike Euler angles they have no gimbal lock and interpolate smoothly with Slerp.
+type Quaternion [4]float64
+
+// Creates a quaternion from its parts, normalized so it is a rotation. A zero quaternion gives no rotation.
+func NewQuaternion(x, y, z, w float64) *Quaternion {
+	q := Quaternion{x, y, z, w}
+	length := math.Sqrt(q.Dot(&q))
+	if length == 0 {
+		return &Quaternion{0, 0, 0, 1}
+	}
+	return &Quaternion{x / length, y / length, z / length, w / length}
+}
+
+// Rotation of angle degrees anticlockwise about axis.
+func QuaternionAxisAngle(axis *Vec3, angle float64) *Quaternion {
+	if axis.near_zero() {
+		return &Quaternion{0, 0, 0, 1}
+	}
+	k := axis.Unit()
+	half := angle * math.Pi / 360
+	sin := math.Sin(half)
+	return &Quaternion{k[0] * sin, k[1] * sin, k[2] * sin, math.Cos(half)}
+}
+
+// Shortest rotation turning direction from to point along to.
+func QuaternionBetween(from, to *Vec3) *Quaternion {
+	if from.near_zero() || to.near_zero() {
+		return &Quaternion{0, 0, 0, 1}
+	}
+	a, b := from.Unit(), to.Unit()
+	cos := Dot(a, b)
+	if cos < -1+1e-9 {
+		// Opposite directions, any axis at right angles will do.
+		axis, _ := build_onb(a)
+		return &Quaternion{axis[0], axis[1], axis[2], 0}
+	}
+	// Half way between no rotation and twice the rotation, which avoids any trigonometry.
+	axis := Cross(a, b)
+	return NewQuaternion(axis[0], axis[1], axis[2], 1+cos)
+}
+
+// Rotation aiming an object's +z axis along direction, with its +y axis as close to up as it can be.
+func QuaternionLookAt(direction, up *Vec3) *Quaternion {
+	if direction.near_zero() {
+		return &Quaternion{0, 0, 0, 1}
+	}
+	forward := direction.Unit()
+	right := Cross(up, forward)
+	if right.near_zero() {
+		// Looking straight along up, so any roll will do.
+		return QuaternionBetween(NewVec3(0, 0, 1), forward)
+	}
+	right = right.Unit()
+	new_up := Cross(forward, right)
+
+	matrix := IdentityMat4()
+	for row := 0; row < 3; row++ {
+		matrix[row][0], matrix[row][1], matrix[row][2] = right[row], new_up[row], forward[row]
+	}
+	return QuaternionFromMat4(&matrix)
+}
+
+// Rotation part of matrix, which is assumed to have no scaling or shearing.
+func QuaternionFromMat4(matrix *Mat4) *Quaternion {
+	m := matrix
+	trace := m[0][0] + m[1][1] + m[2][2]
+
+	// Work from whichever of w, x, y and z is largest, to avoid dividing by something near zero.
+	switch {
+	case trace > 0:
+		s := 2 * math.Sqrt(trace+1)
+		return NewQuaternion((m[2][1]-m[1][2])/s, (m[0][2]-m[2][0])/s, (m[1][0]-m[0][1])/s, s/4)
+	case m[0][0] > m[1][1] && m[0][0] > m[2][2]:
+		s := 2 * math.Sqrt(1+m[0][0]-m[1][1]-m[2][2])
+		return NewQuaternion(s/4, (m[0][1]+m[1][0])/s, (m[0][2]+m[2][0])/s, (m[2][1]-m[1][2])/s)
+	case m[1][1] > m[2][2]:
+		s := 2 * math.Sqrt(1+m[1][1]-m[0][0]-m[2][2])
+		return NewQuaternion((m[0][1]+m[1][0])/s, s/4, (m[1][2]+m[2][1])/s, (m[0][2]-m[2][0])/s)
+	default:
+		s := 2 * math.Sqrt(1+m[2][2]-m[0][0]-m[1][1])
+		return NewQuaternion((m[0][2]+m[2][0])/s, (m[1][2]+m[2][1])/s, s/4, (m[1][0]-m[0][1])/s)
+	}
+}
+
+func (q1 *Quaternion) Dot(q2 *Quaternion) float64 {
+	return q1[0]*q2[0] + q1[1]*q2[1] + q1[2]*q2[2] + q1[3]*q2[3]
+}
+
+// Product q1 * q2, which rotates by q2 and then by q1.
+func (q1 *Quaternion) Mul(q2 *Quaternion) *Quaternion {
+	x1, y1, z1, w1 := q1[0], q1[1], q1[2], q1[3]
+	x2, y2, z2, w2 := q2[0], q2[1], q2[2], q2[3]
+	return &Quaternion{
+		w1*x2 + x1*w2 + y1*z2 - z1*y2,
+		w1*y2 - x1*z2 + y1*w2 + z1*x2,
+		w1*z2 + x1*y2 - y1*x2 + z1*w2,
+		w1*w2 - x1*x2 - y1*y2 - z1*z2,
+	}
+}
+
+// The opposite rotation
+func (q1 *Quaternion) Conjugate() *Quaternion {
+	return &Quaternion{-q1[0], -q1[1], -q1[2], q1[3]}
+}
+
+// Rotate the vector v
+func (q1 *Quaternion) Rotate(v *Vec3) *Vec3 {
+	matrix := q1.Mat4()
+	return matrix.TransformVector(v)
+}
+
+// Rotation matrix of the quaternion
+func (q1 *Quaternion) Mat4() Mat4 {
+	return QuaternionMat4(q1[0], q1[1], q1[2], q1[3])
+}
+
+// Spherical linear interpolation from q1 at t = 0 to q2 at t = 1, turning at a constant rate the short way round.
+func Slerp(q1, q2 *Quaternion, t float64) *Quaternion {
+	// q and -q are the same rotation, pick the one closer to q1.
+	end := *q2
+	cos := q1.Dot(q2)
+	if cos < 0 {
+		end, cos = Quaternion{-q2[0], -q2[1], -q2[2], -q2[3]}, -cos
+	}
+
+	// Nearly the same orientation, where sin(theta) is too small to divide by and a straight line is as good.
+	a, b := 1-t, t
+	if cos < 0.9995 {
+		theta := math.Acos(cos)
+		sin := math.Sin(theta)
+		a, b = math.Sin((1-t)*theta)/sin, math.Sin(t*theta)/sin
+	}
+	return NewQuaternion(a*q1[0]+b*end[0], a*q1[1]+b*end[1], a*q1[2]+b*end[2], a*q1[3]+b*end[3])
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+// A scene loaded from a file: the objects, where to look at them from, and any lights that aren't objects.
+type Scene struct {
+	world  *Hit_List
+	views  []View  // Cameras defined by the scene, the first is used by default
+	lights []Light // Lights rays can't hit, such as point lights
+
+	warnings []error // Parts of the file that were skipped or replaced while loading
+}
+
+// Problems loading the scene that didn't stop it loading, for the caller to report.
+func (scene *Scene) Warnings() []error {
+	return scene.warnings
+}
+
+// Where a camera is and how much it sees, without the image size that NewCamera also needs.
+type View struct {
+	lookfrom, lookat, vup Vec3
+	vfov                  float64    // Vertical field of view in degrees, or the angle across the image circle of a fisheye
+	projection            Projection // Perspective unless set
+	ortho_height          float64    // Height seen by an orthographic camera in world units
+	aspect_ratio          float64
+	mirrored              bool // Left and right swapped, as in left-handed formats like PBRT
+}
+
+// Every light in the scene, emissive objects and the rest, for sampling them directly.
+func (scene *Scene) LightTree() *LightTree {
+	return NewLightTree(append(Emitters(scene.world), scene.lights...)...)
+}
+
+// Camera for the scene's view. Direct light sampling is turned on, as otherwise lights that rays can't hit would be missing.
+func (scene *Scene) Camera(image_width int, background Vec3) *camera {
+	view := scene.View()
+	cam := view.Camera(image_width, background)
+	cam.lights = scene.LightTree()
+	return cam
+}
+
+// The scene's first view, or one looking at the whole world down -z if it has none.
+func (scene *Scene) View() View {
+	if len(scene.views) > 0 {
+		return scene.views[0]
+	}
+	return default_view(scene.world.bounding_box())
+}
+
+// Animation of frames images circling the scene once, with the camera orbiting about its view's vup through lookat.
+// Nothing in the scene moves, so there is no motion blur.
+func (scene *Scene) Turntable(frames int, frame_rate float64, image_width int, background Vec3) *Animation {
+	view := scene.View()
+	lights := scene.LightTree()
+	return NewAnimation(0, frames-1, frame_rate, func(time float64) (Hittable, *camera) {
+		orbit := view.Orbit(360 * time * frame_rate / float64(frames))
+		cam := orbit.Camera(image_width, background)
+		cam.lights = lights
+		return scene.world, cam
+	})
+}
+
+// Load a scene from a glTF, PBRT, OBJ, PLY or STL file, picked by its extension.
+// Meshes are put in a BVH, and STL meshes, having no materials, are a plain grey.
+func LoadScene(filename string) (*Scene, error) {
+	var mesh *TriangleMesh
+	var err error
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".gltf", ".glb":
+		return NewGltf(filename)
+	case ".pbrt":
+		return NewPbrt(filename)
+	case ".obj":
+		mesh, err = NewObj(filename)
+	case ".ply":
+		mesh, err = NewPly(filename)
+	case ".stl":
+		mesh, err = NewStl(filename, NewLambert(*NewVec3(0.73, 0.73, 0.73)))
+	default:
+		return nil, fmt.Errorf("%s: unknown scene format", filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(mesh.normals) == 0 {
+		mesh.ComputeNormals(60)
+	}
+	return &Scene{world: NewList(mesh.BVH()), warnings: mesh.Warnings()}, nil
+}
+
+// Camera for this view with its projection, with a pinhole lens if it's perspective.
+func (view *View) Camera(image_width int, background Vec3) *camera {
+	focus_distance := view.lookat.Sub(&view.lookfrom).Magnitude()
+	if focus_distance == 0 {
+		focus_distance = 1
+	}
+	aspect_ratio := view.aspect_ratio
+	if aspect_ratio <= 0 {
+		aspect_ratio = 16.0 / 9.0
+	}
+	cam := NewCamera(image_width, view.lookfrom, view.lookat, view.vup, view.vfov, aspect_ratio, focus_distance, 0, background)
+	switch view.projection {
+	case ProjectionOrthographic:
+		cam.Orthographic(view.ortho_height)
+	case ProjectionEquirectangular:
+		cam.Equirectangular()
+	case ProjectionCubeMap:
+		cam.CubeMap()
+	case ProjectionFisheyeEquidistant, ProjectionFisheyeEquisolid:
+		cam.Fisheye(view.projection, view.vfov)
+	}
+	if view.mirrored {
+		cam.mirror()
+	}
+	return cam
+}
+
+// The view with lookfrom moved angle degrees anticlockwise around lookat, about vup.
+func (view *View) Orbit(angle float64) View {
+	orbit := *view
+	rotation := AxisAngleMat4(&view.vup, angle)
+	orbit.lookfrom = *view.lookat.Add(rotation.TransformVector(view.lookfrom.Sub(&view.lookat)))
+	return orbit
+}
+
+// View from far enough along +z to see all of bbox.
+func default_view(bbox *AABB) View {
+	center := bbox.center()
+	radius := bbox.maxVec.Sub(&center).Magnitude()
+	if math.IsInf(radius, 0) || math.IsNaN(radius) || radius == 0 {
+		center, radius = Vec3{}, 1
+	}
+	vfov := 40.0
+	distance := radius / math.Sin(vfov/2*math.Pi/180)
+	return View{
+		lookfrom: *center.Add(NewVec3(0, 0, distance)),
+		lookat:   center,
+		vup:      *NewVec3(0, 1, 0),
+		vfov:     vfov,
+	}
+}
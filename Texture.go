@@ -2,7 +2,8 @@ package main
 
 import (
 	"image"
-	"image/png"
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
 	"math"
 )
@@ -11,6 +12,19 @@ type Texture interface {
 	value(u, v float64, point Vec3) Vec3
 }
 
+// Textures that need more of the hit than u, v and the point.
+type hit_texture interface {
+	hit_value(hit *Hit) Vec3
+}
+
+// Look up a texture at a hit.
+func texture_value(texture *Texture, hit *Hit) Vec3 {
+	if tex, ok := (*texture).(hit_texture); ok {
+		return tex.hit_value(hit)
+	}
+	return (*texture).value(hit.u, hit.v, hit.point)
+}
+
 // Constant Color Texture
 type Solid struct {
 	albedo Vec3
@@ -66,8 +80,9 @@ type Image struct {
 	width, height int
 }
 
+// Create an image texture from a PNG or JPEG, nil if it can't be decoded.
 func NewImageTexture(rc io.Reader) *Texture {
-	im, err := png.Decode(rc)
+	im, _, err := image.Decode(rc)
 	if err != nil {
 		return nil
 	}
@@ -99,6 +114,29 @@ func (im *Image) value(u, v float64, point Vec3) Vec3 {
 	return *NewVec3(float64(r)/65535, float64(g)/65535, float64(b)/65535)
 }
 
+// Vertex Colour Texture, for meshes that give each vertex a colour
+type VertexColor struct {
+	fallback Vec3
+}
+
+// Create a texture showing the colours interpolated across a mesh, fallback elsewhere.
+func NewVertexColorTexture(fallback Vec3) *Texture {
+	var color Texture = &VertexColor{fallback}
+	return &color
+}
+
+// Without the hit there's no vertex colour to use.
+func (color *VertexColor) value(u, v float64, point Vec3) Vec3 {
+	return color.fallback
+}
+
+func (color *VertexColor) hit_value(hit *Hit) Vec3 {
+	if !hit.colored {
+		return color.fallback
+	}
+	return hit.color
+}
+
 // Perlin Noise Texture
 type Noise struct {
 	scale float64
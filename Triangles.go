@@ -1,6 +1,9 @@
 package main
 
-import "math"
+import (
+	"math"
+	"math/rand/v2"
+)
 
 type Triangle struct {
 	Q        Vec3 // A corner on the Triangle
@@ -9,7 +12,9 @@ type Triangle struct {
 	material *Material
 	normal   Vec3    // The normal
 	D        float64 // Constant D
+	area     float64
 	bbox     AABB
+	normals  *[3]Vec3 // Vertex normals at Q, Q+u and Q+v for smooth shading, nil for flat
 }
 
 // Create a new Quadrilaterial plane Given a point and two direction vectors
@@ -24,11 +29,27 @@ func NewTriangle(Q *Vec3, u, v *Vec3, material *Material) *Triangle {
 		material: material,
 		normal:   *normal,
 		D:        Dot(normal, Q),
+		area:     n.Magnitude() / 2,
 		// Compute the bounding box of all four vertices.
 		bbox: *MergeAABB(*NewAABB(*Q, *Q.Add(u).Add(v)), *NewAABB(*Q.Add(u), *Q.Add(v))),
 	}
 }
 
+// Create a triangle shaded smoothly by interpolating the unit normals n0, n1 and n2 at its corners Q, Q+u and Q+v.
+func NewSmoothTriangle(Q *Vec3, u, v *Vec3, n0, n1, n2 *Vec3, material *Material) *Triangle {
+	tri := NewTriangle(Q, u, v, material)
+	tri.normals = &[3]Vec3{*n0, *n1, *n2}
+	return tri
+}
+
+// Move the triangle to new corners, taking the same arguments as NewTriangle.
+// Any BVH containing it needs refitting afterwards.
+func (tri *Triangle) SetVertices(Q *Vec3, u, v *Vec3) {
+	normals := tri.normals
+	*tri = *NewTriangle(Q, u, v, tri.material)
+	tri.normals = normals
+}
+
 // If you do the path for a ray intersecting with a plane (tip, represent the plane in point normal form)
 // You will find that the intersections t is equal to t = (D - n.P)/(n.d)
 // where n is the normal, P and d are the origin point and direction of the Ray, D is n.v, where v is the point of intersection.
@@ -62,10 +83,60 @@ func (tri *Triangle) hit(ray *Ray, ray_tmin float64, ray_tmax float64, record *H
 	record.t = t
 	record.point = intersection
 	record.material = tri.material
-	record.set_face_normal(ray, tri.normal)
+	if tri.normals != nil {
+		record.set_shading_normal(ray, tri.normal, interpolate_normal(&tri.normals[0], &tri.normals[1], &tri.normals[2], alpha, beta))
+	} else {
+		record.set_face_normal(ray, tri.normal)
+	}
+	record.dpdu, record.dpdv = tri.u, tri.v
 	return true
 }
 
 func (tri *Triangle) bounding_box() (bounds *AABB) {
 	return &tri.bbox
 }
+
+// Samples a point uniformly over the area of the triangle and converts the pdf to solid angle as seen from origin.
+func (tri *Triangle) sample_light(origin *Vec3, time float64) (direction Vec3, distance float64, emission Vec3, pdf float64) {
+	// Fold samples from the far half of the parallelogram back into the triangle.
+	alpha, beta := rand.Float64(), rand.Float64()
+	if alpha+beta > 1 {
+		alpha, beta = 1-alpha, 1-beta
+	}
+	point := tri.Q.Add(tri.u.Scale(alpha)).Add(tri.v.Scale(beta))
+
+	to_light := point.Sub(origin)
+	distance_squared := to_light.Length_Squared()
+	distance = math.Sqrt(distance_squared)
+	direction = *to_light.Scale(1 / distance)
+
+	cosine := math.Abs(Dot(&direction, &tri.normal))
+	if cosine < 1e-8 {
+		return direction, distance, Vec3{}, 0
+	}
+
+	emission = (*tri.material).emitted(alpha, beta, point)
+	return direction, distance, emission, distance_squared / (cosine * tri.area)
+}
+
+// Triangles emit from both faces, all along the normal.
+func (tri *Triangle) light_bounds() LightBounds {
+	emission := (*tri.material).emitted(1.0/3, 1.0/3, tri.Q.Add(tri.u.Add(&tri.v).Scale(1.0/3)))
+	return LightBounds{
+		bbox:        tri.bbox,
+		w:           tri.normal,
+		phi:         emission.Luminance() * tri.area * 2,
+		cos_theta_o: 1,
+		cos_theta_e: 0,
+		two_sided:   true,
+	}
+}
+
+// Blend the corner normals of a triangle by the barycentric coordinates of the second and third corners.
+func interpolate_normal(n0, n1, n2 *Vec3, alpha, beta float64) Vec3 {
+	n := n0.Scale(1 - alpha - beta).Add(n1.Scale(alpha)).Add(n2.Scale(beta))
+	if n.near_zero() {
+		return *n0
+	}
+	return *n.Unit()
+}
@@ -185,18 +185,26 @@ func (v1 Vec3) RotateGen(alpha, beta, gamma float64) *Vec3 {
 	}
 }
 
-func (rotate *Rotate) RotateAntiClockWise(v1 *Vec3) *Vec3 {
-	return &Vec3{
-		rotate.alpha_cos*rotate.beta_cos*v1[0] + ((rotate.alpha_cos*rotate.beta_sin*rotate.gamma_sin)-(rotate.alpha_sin*rotate.gamma_cos))*v1[1] + ((rotate.alpha_cos*rotate.beta_sin*rotate.gamma_cos)+(rotate.alpha_sin*rotate.gamma_sin))*v1[2],
-		rotate.alpha_sin*rotate.beta_cos*v1[0] + ((rotate.alpha_sin*rotate.beta_sin*rotate.gamma_sin)+(rotate.alpha_cos*rotate.gamma_cos))*v1[1] + ((rotate.alpha_sin*rotate.beta_sin*rotate.gamma_cos)-(rotate.alpha_cos*rotate.gamma_sin))*v1[2],
-		-rotate.beta_sin*(v1[0]) + (rotate.beta_cos*rotate.gamma_sin)*v1[1] + (rotate.beta_cos * rotate.gamma_cos * v1[2]),
+// Builds an orthonormal basis (u, v) perpendicular to the unit vector w.
+func build_onb(w *Vec3) (u, v Vec3) {
+	var a *Vec3
+	if math.Abs(w[0]) > 0.9 {
+		a = NewVec3(0, 1, 0)
+	} else {
+		a = NewVec3(1, 0, 0)
 	}
+	v = *Cross(w, a).Unit()
+	u = *Cross(w, &v)
+	return u, v
 }
 
-func (rotate *Rotate) RotateClockwise(v1 *Vec3) *Vec3 {
-	return &Vec3{
-		rotate.alpha_cos*rotate.beta_cos*v1[0] + ((rotate.alpha_cos*rotate.beta_sin*rotate.gamma_sin)+(rotate.alpha_sin*rotate.gamma_cos))*v1[1] + (-(rotate.alpha_cos*rotate.beta_sin*rotate.gamma_cos)+(rotate.alpha_sin*rotate.gamma_sin))*v1[2],
-		-rotate.alpha_sin*rotate.beta_cos*v1[0] + (-(rotate.alpha_sin*rotate.beta_sin*rotate.gamma_sin)+(rotate.alpha_cos*rotate.gamma_cos))*v1[1] + ((rotate.alpha_sin*rotate.beta_sin*rotate.gamma_cos)+(rotate.alpha_cos*rotate.gamma_sin))*v1[2],
-		rotate.beta_sin*(v1[0]) - (rotate.beta_cos*rotate.gamma_sin)*v1[1] + (rotate.beta_cos * rotate.gamma_cos * v1[2]),
-	}
+// Rotate v by theta radians about the unit axis k (Rodrigues' rotation formula)
+func (v1 *Vec3) RotateAxis(k *Vec3, theta float64) *Vec3 {
+	cos, sin := math.Cos(theta), math.Sin(theta)
+	return v1.Scale(cos).Add(Cross(k, v1).Scale(sin)).Add(k.Scale(Dot(k, v1) * (1 - cos)))
+}
+
+// Relative luminance of a linear RGB color
+func (v1 *Vec3) Luminance() float64 {
+	return 0.2126*v1[0] + 0.7152*v1[1] + 0.0722*v1[2]
 }
@@ -2,12 +2,15 @@ package main
 
 import (
 	"math"
+	"runtime"
 	"sort"
+	"sync"
 )
 
 type BVH struct {
-	left, right *Hittable
+	left, right *Hittable // right is nil for leaves holding several objects in left
 	aabb        AABB
+	builder     *sah_builder // Settings of a SAH build, only set on its root, so it can be rebuilt the same way
 }
 
 func NewBVHNode(objects []Hittable) *BVH {
@@ -47,9 +50,12 @@ func NewBVHNode(objects []Hittable) *BVH {
 		})
 
 		mid := len(objects) / 2
-		var thing Hittable = NewBVHNode(objects[0:mid])
+		thing, thing2 := build_subtrees(func() Hittable {
+			return NewBVHNode(objects[0:mid])
+		}, func() Hittable {
+			return NewBVHNode(objects[mid:])
+		}, mid > parallel_build_threshold)
 		node.left = &thing
-		var thing2 Hittable = NewBVHNode(objects[mid:])
 		node.right = &thing2
 	}
 
@@ -63,6 +69,9 @@ func (node *BVH) hit(ray *Ray, ray_tmin float64, ray_tmax float64, record *Hit)
 
 	var hit_left, hit_right bool
 	hit_left = (*node.left).hit(ray, ray_tmin, ray_tmax, record)
+	if node.right == nil {
+		return hit_left
+	}
 	if hit_left {
 		hit_right = (*node.right).hit(ray, ray_tmin, record.t, record)
 	} else {
@@ -76,3 +85,478 @@ func (node *BVH) hit(ray *Ray, ray_tmin float64, ray_tmax float64, record *Hit)
 func (node *BVH) bounding_box() (bounds *AABB) {
 	return &node.aabb
 }
+
+// Number of buckets the centroids are binned into when evaluating SAH splits.
+const sah_buckets = 16
+
+// Subtrees with fewer objects than this are built on the current goroutine, as handing them off costs more than it saves.
+const parallel_build_threshold = 4096
+
+// Nodes with more objects than this bin their objects across several goroutines.
+const parallel_binning_threshold = 65536
+
+// Limits how many BVH subtrees are built on other goroutines at once.
+var build_workers = make(chan struct{}, runtime.NumCPU())
+
+// Settings shared by every node of a SAH build.
+type sah_builder struct {
+	leaf_size                      int
+	traversal_cost, intersect_cost float64
+}
+
+// Object counts and bounds per bucket, along each axis.
+type sah_bins struct {
+	counts [3][sah_buckets]int
+	boxes  [3][sah_buckets]AABB
+}
+
+// Build a BVH using the binned surface area heuristic.
+// Leaves hold up to leaf_size objects, and nodes are only split when the estimated cost of
+// traversing the children (traversal_cost plus intersect_cost per object, weighted by area) is lower than intersecting every object.
+// Subtrees and binning are spread across all CPUs, but the tree only depends on the input order, not on scheduling.
+func NewSAHBVH(objects []Hittable, leaf_size int, traversal_cost, intersect_cost float64) *BVH {
+	if leaf_size < 1 {
+		leaf_size = 1
+	}
+
+	builder := sah_builder{
+		leaf_size:      leaf_size,
+		traversal_cost: traversal_cost,
+		intersect_cost: intersect_cost,
+	}
+
+	// Partitioning reorders the slice, so work on a copy.
+	temp := make([]Hittable, len(objects))
+	copy(temp, objects)
+
+	root := builder.build(temp)
+	if node, ok := root.(*BVH); ok {
+		node.builder = &builder
+		return node
+	}
+
+	// A single object still gets wrapped so callers always get a BVH back.
+	return &BVH{left: &root, aabb: *root.bounding_box(), builder: &builder}
+}
+
+func (builder *sah_builder) build(objects []Hittable) Hittable {
+	if len(objects) == 1 {
+		return objects[0]
+	}
+
+	bbox, centroids := object_bounds(objects)
+	bins := bin_objects(objects, &centroids)
+
+	// Evaluate every bucket boundary along all three axes.
+	leaf_cost := builder.intersect_cost * float64(len(objects))
+	best_axis, best_split, best_cost := -1, 0, math.Inf(1)
+	for axis := 0; axis < 3; axis++ {
+		if centroids.maxVec[axis]-centroids.minVec[axis] <= 0 {
+			continue
+		}
+
+		// Sweep from the right to get the area and count above each boundary, then from the left.
+		var above_area [sah_buckets]float64
+		var above_count [sah_buckets]int
+		above, count := *NewEmptyAABB(), 0
+		for i := sah_buckets - 1; i > 0; i-- {
+			above = *MergeAABB(above, bins.boxes[axis][i])
+			count += bins.counts[axis][i]
+			above_area[i-1], above_count[i-1] = above.surface_area(), count
+		}
+
+		below, count := *NewEmptyAABB(), 0
+		for split := 0; split < sah_buckets-1; split++ {
+			below = *MergeAABB(below, bins.boxes[axis][split])
+			count += bins.counts[axis][split]
+			if count == 0 || above_count[split] == 0 {
+				continue
+			}
+			cost := builder.traversal_cost + builder.intersect_cost*(below.surface_area()*float64(count)+above_area[split]*float64(above_count[split]))/bbox.surface_area()
+			if cost < best_cost {
+				best_axis, best_split, best_cost = axis, split, cost
+			}
+		}
+	}
+
+	if len(objects) <= builder.leaf_size && leaf_cost <= best_cost {
+		var leaf Hittable = NewList(objects...)
+		return leaf
+	}
+
+	mid := len(objects) / 2
+	if best_axis >= 0 {
+		mid = partition_objects(objects, best_axis, best_split, &centroids)
+	}
+
+	left, right := build_subtrees(func() Hittable {
+		return builder.build(objects[:mid])
+	}, func() Hittable {
+		return builder.build(objects[mid:])
+	}, mid > parallel_build_threshold)
+
+	return &BVH{left: &left, right: &right, aabb: bbox}
+}
+
+// Build two subtrees, handing the left one to another goroutine if parallel is set and a worker is free.
+func build_subtrees(build_left, build_right func() Hittable, parallel bool) (left, right Hittable) {
+	if parallel {
+		select {
+		case build_workers <- struct{}{}:
+			done := make(chan struct{})
+			go func() {
+				left = build_left()
+				<-build_workers
+				close(done)
+			}()
+			right = build_right()
+			<-done
+			return left, right
+		default:
+		}
+	}
+	return build_left(), build_right()
+}
+
+// Split a slice into one chunk per CPU and run fn on each chunk concurrently.
+func parallel_chunks(n int, fn func(chunk, lo, hi int)) {
+	chunks := runtime.NumCPU()
+	size := (n + chunks - 1) / chunks
+
+	var wg sync.WaitGroup
+	for chunk := 0; chunk < chunks; chunk++ {
+		lo, hi := chunk*size, min((chunk+1)*size, n)
+		if lo >= hi {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(chunk, lo, hi)
+		}()
+	}
+	wg.Wait()
+}
+
+// Bounds of the objects and of their centroids.
+func object_bounds(objects []Hittable) (bbox, centroids AABB) {
+	bounds := func(objects []Hittable) (AABB, AABB) {
+		bbox, centroids := *NewEmptyAABB(), *NewEmptyAABB()
+		for _, object := range objects {
+			box := object.bounding_box()
+			c := box.center()
+			bbox.IMerge(box)
+			centroids.IMerge(&AABB{c, c})
+		}
+		return bbox, centroids
+	}
+
+	if len(objects) <= parallel_binning_threshold {
+		return bounds(objects)
+	}
+
+	boxes := make([][2]AABB, runtime.NumCPU())
+	for i := range boxes {
+		boxes[i] = [2]AABB{*NewEmptyAABB(), *NewEmptyAABB()}
+	}
+	parallel_chunks(len(objects), func(chunk, lo, hi int) {
+		boxes[chunk][0], boxes[chunk][1] = bounds(objects[lo:hi])
+	})
+
+	bbox, centroids = *NewEmptyAABB(), *NewEmptyAABB()
+	for _, box := range boxes {
+		bbox.IMerge(&box[0])
+		centroids.IMerge(&box[1])
+	}
+	return bbox, centroids
+}
+
+// Count and bound the objects falling in each bucket of the centroid bounds.
+func bin_objects(objects []Hittable, centroids *AABB) *sah_bins {
+	bin := func(objects []Hittable) *sah_bins {
+		var bins sah_bins
+		empty := *NewEmptyAABB()
+		for axis := 0; axis < 3; axis++ {
+			for i := range bins.boxes[axis] {
+				bins.boxes[axis][i] = empty
+			}
+		}
+		for _, object := range objects {
+			box := object.bounding_box()
+			c := box.center()
+			for axis := 0; axis < 3; axis++ {
+				if centroids.maxVec[axis]-centroids.minVec[axis] <= 0 {
+					continue
+				}
+				b := sah_bucket(&c, axis, centroids)
+				bins.counts[axis][b]++
+				bins.boxes[axis][b].IMerge(box)
+			}
+		}
+		return &bins
+	}
+
+	if len(objects) <= parallel_binning_threshold {
+		return bin(objects)
+	}
+
+	partial := make([]*sah_bins, runtime.NumCPU())
+	parallel_chunks(len(objects), func(chunk, lo, hi int) {
+		partial[chunk] = bin(objects[lo:hi])
+	})
+
+	// Counts add and boxes merge the same way whatever order the chunks finished in.
+	bins := partial[0]
+	for _, other := range partial[1:] {
+		if other == nil {
+			continue
+		}
+		for axis := 0; axis < 3; axis++ {
+			for i := 0; i < sah_buckets; i++ {
+				bins.counts[axis][i] += other.counts[axis][i]
+				bins.boxes[axis][i].IMerge(&other.boxes[axis][i])
+			}
+		}
+	}
+	return bins
+}
+
+// Stable partition of the objects into those at or below the split bucket and those above it, returning the boundary.
+func partition_objects(objects []Hittable, axis, split int, centroids *AABB) int {
+	below := make([]Hittable, 0, len(objects))
+	var above []Hittable
+	for _, object := range objects {
+		c := object.bounding_box().center()
+		if sah_bucket(&c, axis, centroids) <= split {
+			below = append(below, object)
+		} else {
+			above = append(above, object)
+		}
+	}
+	copy(objects, below)
+	copy(objects[len(below):], above)
+	return len(below)
+}
+
+// Which bucket a centroid falls in along axis.
+func sah_bucket(c *Vec3, axis int, centroids *AABB) int {
+	lo, hi := centroids.minVec[axis], centroids.maxVec[axis]
+	b := int(sah_buckets * (c[axis] - lo) / (hi - lo))
+	if b >= sah_buckets {
+		b = sah_buckets - 1
+	}
+	if b < 0 {
+		b = 0
+	}
+	return b
+}
+
+// SAHCost estimates the expected cost of tracing a ray through the tree, so trees from different builders can be compared.
+func (node *BVH) SAHCost(traversal_cost, intersect_cost float64) float64 {
+	return sah_cost(node, traversal_cost, intersect_cost)
+}
+
+func sah_cost(object Hittable, traversal_cost, intersect_cost float64) float64 {
+	switch obj := object.(type) {
+	case *BVH:
+		area := obj.aabb.surface_area()
+		if area == 0 {
+			return traversal_cost
+		}
+		cost := traversal_cost + (*obj.left).bounding_box().surface_area()/area*sah_cost(*obj.left, traversal_cost, intersect_cost)
+		if obj.right != nil {
+			cost += (*obj.right).bounding_box().surface_area() / area * sah_cost(*obj.right, traversal_cost, intersect_cost)
+		}
+		return cost
+	case *Hit_List:
+		return intersect_cost * float64(len(obj.list))
+	default:
+		return intersect_cost
+	}
+}
+
+// BVH compacted into a single array of nodes, traversed with an explicit stack instead of recursing through interface calls.
+type FlatBVH struct {
+	nodes      []flat_node
+	objects    []Hittable  // Objects of every leaf, each leaf's objects are contiguous
+	builder    sah_builder // Leaf size and costs used again when rebuilding
+	build_cost float64     // SAH cost when the tree was built, to tell how far refitting has degraded it
+}
+
+type flat_node struct {
+	aabb   AABB
+	offset int  // Leaves: index of the first object. Interior nodes: index of the second child, the first child directly follows its parent.
+	count  int  // Number of objects in a leaf, 0 for interior nodes
+	axis   int  // Axis the children are split along, used to visit the nearer child first
+	flip   bool // The first child is the upper one along axis, once refitting has moved the objects
+}
+
+// Flatten compacts a built BVH into a FlatBVH, collapsing leaves that hold the same object twice.
+// Trees not built by NewSAHBVH are rebuilt with unit costs and their largest leaf size.
+func (node *BVH) Flatten() *FlatBVH {
+	var flat FlatBVH
+	flat.flatten(node)
+	if node.builder != nil {
+		flat.builder = *node.builder
+	} else {
+		flat.builder.traversal_cost, flat.builder.intersect_cost = 1, 1
+	}
+	flat.build_cost = flat.SAHCost(flat.builder.traversal_cost, flat.builder.intersect_cost)
+	return &flat
+}
+
+// Append the subtree rooted at object in depth first order, returning the index of its node.
+func (flat *FlatBVH) flatten(object Hittable) int {
+	index := len(flat.nodes)
+	flat.nodes = append(flat.nodes, flat_node{aabb: *object.bounding_box()})
+
+	switch obj := object.(type) {
+	case *BVH:
+		if obj.right == nil || obj.right == obj.left {
+			flat.nodes = flat.nodes[:index]
+			return flat.flatten(*obj.left)
+		}
+
+		// The lower child along the split axis is stored first.
+		first, last := *obj.left, *obj.right
+		axis, flip := split_axis(first.bounding_box(), last.bounding_box())
+		if flip {
+			first, last = last, first
+		}
+
+		flat.flatten(first)
+		second := flat.flatten(last)
+
+		flat.nodes[index].offset = second
+		flat.nodes[index].axis = axis
+	case *Hit_List:
+		flat.nodes[index].offset = len(flat.objects)
+		flat.nodes[index].count = len(obj.list)
+		flat.objects = append(flat.objects, obj.list...)
+		flat.builder.leaf_size = max(flat.builder.leaf_size, len(obj.list))
+	default:
+		flat.nodes[index].offset = len(flat.objects)
+		flat.nodes[index].count = 1
+		flat.objects = append(flat.objects, object)
+		flat.builder.leaf_size = max(flat.builder.leaf_size, 1)
+	}
+
+	return index
+}
+
+// Axis separating the centres of two children the most, and whether first is the upper one along it.
+func split_axis(first, last *AABB) (axis int, flip bool) {
+	first_center, last_center := first.center(), last.center()
+	widest := 0.0
+	for i := 0; i < 3; i++ {
+		if d := math.Abs(last_center[i] - first_center[i]); d > widest {
+			axis, widest = i, d
+		}
+	}
+	return axis, last_center[axis] < first_center[axis]
+}
+
+func (flat *FlatBVH) hit(ray *Ray, ray_tmin float64, ray_tmax float64, record *Hit) (ok bool) {
+	if len(flat.nodes) == 0 {
+		return false
+	}
+
+	inv_dir := Vec3{1 / ray.direction[0], 1 / ray.direction[1], 1 / ray.direction[2]}
+	dir_is_neg := [3]bool{inv_dir[0] < 0, inv_dir[1] < 0, inv_dir[2] < 0}
+
+	// Nodes still to visit, grows past the array only for very deep trees.
+	var buffer [64]int
+	stack := buffer[:0]
+
+	closest_so_far := ray_tmax
+	anything := false
+	current := 0
+	for {
+		node := &flat.nodes[current]
+		if node.aabb.hit_test_inv(&ray.origin, &inv_dir, ray_tmin, closest_so_far) {
+			if node.count > 0 {
+				for _, object := range flat.objects[node.offset : node.offset+node.count] {
+					if object.hit(ray, ray_tmin, closest_so_far, record) {
+						anything = true
+						closest_so_far = record.t
+					}
+				}
+			} else {
+				// Visit the child nearer to the ray origin first so later boxes can be culled by closest_so_far.
+				if dir_is_neg[node.axis] != node.flip {
+					stack = append(stack, current+1)
+					current = node.offset
+				} else {
+					stack = append(stack, node.offset)
+					current = current + 1
+				}
+				continue
+			}
+		}
+
+		if len(stack) == 0 {
+			break
+		}
+		current = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+	}
+
+	return anything
+}
+
+func (flat *FlatBVH) bounding_box() (bounds *AABB) {
+	if len(flat.nodes) == 0 {
+		return NewEmptyAABB()
+	}
+	return &flat.nodes[0].aabb
+}
+
+// SAHCost estimates the expected cost of tracing a ray through the tree.
+func (flat *FlatBVH) SAHCost(traversal_cost, intersect_cost float64) float64 {
+	if len(flat.nodes) == 0 {
+		return 0
+	}
+
+	// Children always come after their parent, so walking backwards sees them first.
+	costs := make([]float64, len(flat.nodes))
+	for i := len(flat.nodes) - 1; i >= 0; i-- {
+		node := &flat.nodes[i]
+		if node.count > 0 {
+			costs[i] = intersect_cost * float64(node.count)
+			continue
+		}
+
+		area := node.aabb.surface_area()
+		costs[i] = traversal_cost
+		if area > 0 {
+			costs[i] += (flat.nodes[i+1].aabb.surface_area()*costs[i+1] + flat.nodes[node.offset].aabb.surface_area()*costs[node.offset]) / area
+		}
+	}
+	return costs[0]
+}
+
+// Refit recomputes every box bottom-up after the objects have moved, keeping the topology of the tree.
+// If that leaves the tree with a SAH cost more than rebuild_ratio times its cost when built, it is rebuilt from scratch instead.
+func (flat *FlatBVH) Refit(rebuild_ratio float64) (rebuilt bool) {
+	for i := len(flat.nodes) - 1; i >= 0; i-- {
+		node := &flat.nodes[i]
+		if node.count > 0 {
+			node.aabb = *NewEmptyAABB()
+			for _, object := range flat.objects[node.offset : node.offset+node.count] {
+				node.aabb.IMerge(object.bounding_box())
+			}
+		} else {
+			node.aabb = *MergeAABB(flat.nodes[i+1].aabb, flat.nodes[node.offset].aabb)
+			// The children may have passed each other, so pick the nearer one by where they are now.
+			node.axis, node.flip = split_axis(&flat.nodes[i+1].aabb, &flat.nodes[node.offset].aabb)
+		}
+	}
+
+	builder := flat.builder
+	if len(flat.objects) == 0 || flat.SAHCost(builder.traversal_cost, builder.intersect_cost) <= rebuild_ratio*flat.build_cost {
+		return false
+	}
+
+	*flat = *NewSAHBVH(flat.objects, builder.leaf_size, builder.traversal_cost, builder.intersect_cost).Flatten()
+	return true
+}
@@ -14,23 +14,29 @@ import (
 type camera struct {
 	aspect_ratio                   float64
 	image_width                    int
-	image_height                   int     // Rendered image height
-	camera_center                  Vec3    // Camera center
-	pixel00_loc                    Vec3    // Location of pixel 0, 0
-	pixel_delta_u                  Vec3    // Offset to pixel to the right
-	pixel_delta_v                  Vec3    // Offset to pixel below
-	sample_per_pixel               int     // Count of random samples for each pixel
-	pixel_samples_scale            float64 // Color scale factor for a sum of pixel samples
-	max_depth                      int     // Maximum number of ray bounces into scene
-	background                     Vec3    // Scene background color
-	vfov                           float64 // Vertical view angle (field of view) in degrees
-	lookfrom                       Vec3    // Point camera is looking from
-	lookat                         Vec3    // Point camera is looking at
-	vup                            Vec3    // Camera-relative "up" direction
-	u, v, w                        Vec3    // Camera frame basis vectors (u is camera right, v is camera up, w is opposite of view direction)
-	defocus_angle                  float64 // Variation angle of rays through each pixel
-	focus_distance                 float64 // Distance from camera lookfrom point to plane of perfect focus
-	defocus_disk_u, defocus_disk_v Vec3    // Defocus disk horizontal/vertical radius
+	image_height                   int         // Rendered image height
+	camera_center                  Vec3        // Camera center
+	pixel00_loc                    Vec3        // Location of pixel 0, 0
+	pixel_delta_u                  Vec3        // Offset to pixel to the right
+	pixel_delta_v                  Vec3        // Offset to pixel below
+	sample_per_pixel               int         // Count of random samples for each pixel
+	pixel_samples_scale            float64     // Color scale factor for a sum of pixel samples
+	max_depth                      int         // Maximum number of ray bounces into scene
+	background                     *Background // Scene background, evaluated by ray direction
+	vfov                           float64     // Vertical view angle (field of view) in degrees
+	lookfrom                       Vec3        // Point camera is looking from
+	lookat                         Vec3        // Point camera is looking at
+	vup                            Vec3        // Camera-relative "up" direction
+	u, v, w                        Vec3        // Camera frame basis vectors (u is camera right, v is camera up, w is opposite of view direction)
+	defocus_angle                  float64     // Variation angle of rays through each pixel
+	focus_distance                 float64     // Distance from camera lookfrom point to plane of perfect focus
+	defocus_disk_u, defocus_disk_v Vec3        // Defocus disk horizontal/vertical radius
+	accelerator                    Accelerator // Structure the objects of a Hit_List world are put in before rendering
+	lights                         *LightTree  // Emitters sampled directly at diffuse surfaces, nil disables direct light sampling. Build it from Emitters(world) so it has every emitter.
+	shutter_open, shutter_close    float64     // Ray times are spread between these, for motion blur
+	projection                     Projection  // Perspective unless changed by a method such as Orthographic
+	view_height                    float64     // Height of the orthographic viewport in world units
+	fisheye_fov                    float64     // Angle across the image circle of a fisheye projection in degrees
 }
 
 // Makes a new camera given the aspect ratio and image width
@@ -49,7 +55,8 @@ func NewCamera(image_width int, lookFrom, lookAt, vup Vec3, vfov, aspect_ratio,
 	camera.camera_center = lookFrom
 	camera.defocus_angle = defocus_angle
 	camera.focus_distance = focus_distance
-	camera.background = background
+	camera.background = NewConstantBackground(background)
+	camera.shutter_open, camera.shutter_close = 0, 1
 
 	// Calculate the image height, and ensure that it's at least 1.
 	camera.image_height = int(float64(image_width) / float64(aspect_ratio))
@@ -83,19 +90,39 @@ func NewCamera(image_width int, lookFrom, lookAt, vup Vec3, vfov, aspect_ratio,
 	return &camera
 }
 
+// Flip the image left to right, for scenes from left-handed formats.
+func (cam *camera) mirror() {
+	cam.pixel00_loc = *cam.pixel00_loc.Add(cam.pixel_delta_u.Scale(float64(cam.image_width - 1)))
+	cam.pixel_delta_u = *cam.pixel_delta_u.Negate()
+	cam.defocus_disk_u = *cam.defocus_disk_u.Negate()
+	cam.u = *cam.u.Negate()
+}
+
 type result struct {
 	rownum     int
 	pixel_list []Vec3
 }
 
-// Render the scene
+// Render the scene to main.png
 // world Hittable, sample_per_pixel, max_depth int
-// Parallized row by row as well using a worker pool, model is based on this https://gobyexample.com/worker-pools
 func (cam *camera) render(world Hittable, sample_per_pixel, max_depth int) {
+	if err := save_png(cam.render_image(world, sample_per_pixel, max_depth), "main.png"); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// Render the scene to an image.
+// Parallized row by row as well using a worker pool, model is based on this https://gobyexample.com/worker-pools
+func (cam *camera) render_image(world Hittable, sample_per_pixel, max_depth int) *image.NRGBA {
 	cam.sample_per_pixel = sample_per_pixel
 	cam.max_depth = max_depth
 	cam.pixel_samples_scale = 1.0 / float64(cam.sample_per_pixel)
 
+	if list, ok := world.(*Hit_List); ok && cam.accelerator != AccelNone {
+		world = NewAccelerator(cam.accelerator, list.list)
+	}
+
 	jobs := make(chan int, cam.image_height)         // Job channel, indicates the row number
 	results := make(chan result, cam.image_height*2) // Results channel
 
@@ -111,8 +138,10 @@ func (cam *camera) render(world Hittable, sample_per_pixel, max_depth int) {
 				pixel_color := NewVec3(0, 0, 0)
 				// Loop for antialiasing
 				for sample := 0; sample < cam.sample_per_pixel; sample++ {
-					ray := cam.get_ray(float64(col_num), float64(row_num))
-					pixel_color.IAdd((*cam).ray_color(ray, cam.max_depth, world))
+					// Samples outside a fisheye's image circle are black.
+					if ray, ok := cam.get_ray(float64(col_num), float64(row_num)); ok {
+						pixel_color.IAdd((*cam).ray_color(ray, cam.max_depth, world, true))
+					}
 				}
 
 				pixelRow[col_num] = *pixel_color.Scale(cam.pixel_samples_scale).Gamma(2)
@@ -147,28 +176,49 @@ func (cam *camera) render(world Hittable, sample_per_pixel, max_depth int) {
 	}
 	close(results)
 
-	file, err := os.Create("main.png")
+	return img
+}
+
+// Write img to filename as a PNG.
+func save_png(img image.Image, filename string) error {
+	file, err := os.Create(filename)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return err
 	}
-
-	png.Encode(file, img)
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
 }
 
-// Construct a camera ray originating from the defocus disk and directed at a randomly
-// sampled point around the pixel location i, j.
-func (cam *camera) get_ray(i float64, j float64) Ray {
+// Construct a camera ray for a randomly sampled point around the pixel location i, j. For the perspective projection it starts
+// on the defocus disk and for the panoramic ones at lookfrom. ok is false if the point is outside the image circle of a fisheye.
+func (cam *camera) get_ray(i float64, j float64) (ray Ray, ok bool) {
 	offset := sample_square()
+	ray_time := cam.shutter_open + rand.Float64()*(cam.shutter_close-cam.shutter_open)
+
+	switch cam.projection {
+	case ProjectionEquirectangular, ProjectionCubeMap, ProjectionFisheyeEquidistant, ProjectionFisheyeEquisolid:
+		// Fraction of the way across and down the image.
+		x := (i + 0.5 + offset[0]) / float64(cam.image_width)
+		y := (j + 0.5 + offset[1]) / float64(cam.image_height)
+		direction, ok := cam.panoramic_direction(x, y)
+		return Ray{cam.camera_center, direction, ray_time}, ok
+	}
+
 	pixel_sample := cam.pixel00_loc.Add(cam.pixel_delta_u.Scale(i + offset[0])).Add(cam.pixel_delta_v.Scale(j + offset[1]))
+	if cam.projection == ProjectionOrthographic {
+		return Ray{*pixel_sample, *cam.w.Negate(), ray_time}, true
+	}
+
 	ray_origin := cam.camera_center
 	if cam.defocus_angle > 0 {
 		ray_origin = cam.defocus_disk_sample()
 	}
 	ray_direction := pixel_sample.Sub(&ray_origin)
-	ray_time := rand.Float64()
 
-	return Ray{ray_origin, *ray_direction, ray_time}
+	return Ray{ray_origin, *ray_direction, ray_time}, true
 }
 
 func (cam *camera) defocus_disk_sample() Vec3 {
@@ -177,7 +227,9 @@ func (cam *camera) defocus_disk_sample() Vec3 {
 	return *t.Add(cam.defocus_disk_v.Scale(p[1]))
 }
 
-func (camera *camera) ray_color(ray Ray, depth int, world Hittable) *Vec3 {
+// Computes the color seen along a ray. count_emission is false when the previous bounce already sampled the lights directly,
+// so emitters hit by the ray aren't counted twice.
+func (camera *camera) ray_color(ray Ray, depth int, world Hittable, count_emission bool) *Vec3 {
 	// If we've exceeded the ray bounce limit, no more light is gathered.
 	if depth <= 0 {
 		return NewVec3(0, 0, 0)
@@ -185,20 +237,56 @@ func (camera *camera) ray_color(ray Ray, depth int, world Hittable) *Vec3 {
 
 	var rec Hit
 	if !world.hit(&ray, 0.001, math.MaxFloat64, &rec) {
-		return &camera.background
+		color := (*camera.background).value(&ray)
+		return &color
 	}
 
 	var attenuation Vec3
 	var scattered Ray
-	color_from_emission := (*rec.material).emitted(rec.u, rec.v, &rec.point)
+	var color_from_emission Vec3
+	if count_emission || rec.unsampled {
+		color_from_emission = (*rec.material).emitted(rec.u, rec.v, &rec.point)
+	}
 
 	if !(*rec.material).scatter(&ray, &rec, &attenuation, &scattered) {
 		return &color_from_emission
 	}
-	color_from_scatter := (camera.ray_color(scattered, depth-1, world)).Mult(&attenuation)
+
+	// Diffuse surfaces gather light from the emitters directly.
+	if is_diffuse(rec.material) && camera.lights != nil {
+		color_from_lights := camera.direct_light(&ray, &rec, world).Mult(&attenuation)
+		color_from_scatter := (camera.ray_color(scattered, depth-1, world, false)).Mult(&attenuation)
+		return color_from_emission.Add(color_from_lights).Add(color_from_scatter)
+	}
+
+	color_from_scatter := (camera.ray_color(scattered, depth-1, world, true)).Mult(&attenuation)
 	return color_from_emission.Add(color_from_scatter)
 }
 
+// Estimates the light arriving at a diffuse hit from one emitter picked by the light tree, divided by the albedo.
+func (camera *camera) direct_light(ray *Ray, rec *Hit, world Hittable) *Vec3 {
+	light, pmf := camera.lights.sample(&rec.point, &rec.normal)
+	if light == nil {
+		return NewVec3(0, 0, 0)
+	}
+
+	direction, distance, emission, pdf := light.sample_light(&rec.point, ray.time)
+	// Light from below the actual surface can't arrive, whatever the shading normal says.
+	cosine := Dot(&direction, &rec.normal)
+	if pdf <= 0 || cosine <= 0 || Dot(&direction, &rec.geometric_normal) <= 0 {
+		return NewVec3(0, 0, 0)
+	}
+
+	// Check nothing blocks the path to the light.
+	shadow := NewRay(rec.point, direction, ray.time)
+	var blocker Hit
+	if world.hit(&shadow, 0.001, distance-0.001, &blocker) {
+		return NewVec3(0, 0, 0)
+	}
+
+	return emission.Scale(cosine / (math.Pi * pdf * pmf))
+}
+
 func sample_square() Vec3 {
 	return Vec3{rand.Float64() - 0.5, rand.Float64() - 0.5, 0}
 }
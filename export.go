@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Flatten a world into plain triangle meshes for exporting to other tools.
+// Spheres, quads and triangles are tessellated, transforms and instances are applied,
+// and volumes are exported as their boundary. Each top-level object of the world becomes
+// one export_object with a mesh per material.
+
+// Rings and segments of a tessellated sphere.
+const (
+	export_sphere_rings    = 16
+	export_sphere_segments = 32
+)
+
+type export_object struct {
+	name   string
+	meshes []*export_mesh
+}
+
+// Triangles sharing one material, with vertices deduplicated.
+type export_mesh struct {
+	material  *Material
+	positions []Vec3
+	normals   []Vec3       // One per position
+	uvs       [][2]float64 // One per position
+	indices   []int32
+	vertices  map[export_vertex]int32
+}
+
+type export_vertex struct {
+	position, normal Vec3
+	uv               [2]float64
+}
+
+// What other tools can make of one of our materials.
+type export_material struct {
+	name      string
+	kind      string // "diffuse", "metal", "glass" or "light"
+	color     Vec3   // Albedo, or emission for lights
+	roughness float64
+	ior       float64
+	image     image.Image // Base colour image texture of diffuse and metal materials, nil if there is none
+}
+
+// Gathers the objects and materials of a world.
+type exporter struct {
+	objects   []*export_object
+	materials []export_material
+	names     map[*Material]int // Index into materials
+	current   *export_object
+	skipped   map[string]bool // Types of objects that couldn't be exported
+	warnings  []error
+}
+
+// Object to world transform while walking the world, and the material replacing the object's own if not nil.
+type export_state struct {
+	matrix, inverse Mat4
+	material        *Material
+}
+
+func export_world(world Hittable) *exporter {
+	ex := exporter{names: make(map[*Material]int), skipped: make(map[string]bool)}
+	state := export_state{IdentityMat4(), IdentityMat4(), nil}
+
+	objects := []Hittable{world}
+	if list, ok := world.(*Hit_List); ok {
+		objects = list.list
+	}
+	for _, object := range objects {
+		ex.current = &export_object{name: fmt.Sprintf("object_%d", len(ex.objects))}
+		ex.add(object, &state)
+		if len(ex.current.meshes) > 0 {
+			ex.objects = append(ex.objects, ex.current)
+		}
+	}
+	return &ex
+}
+
+// Index of material in ex.materials, described on first use.
+func (ex *exporter) material(material *Material) int {
+	if index, ok := ex.names[material]; ok {
+		return index
+	}
+	described := describe_material(material)
+	described.name = fmt.Sprintf("material_%d", len(ex.materials))
+	ex.names[material] = len(ex.materials)
+	ex.materials = append(ex.materials, described)
+	return ex.names[material]
+}
+
+// Mesh of the current object for material, made on first use.
+func (ex *exporter) mesh(material *Material) *export_mesh {
+	for _, mesh := range ex.current.meshes {
+		if mesh.material == material {
+			return mesh
+		}
+	}
+	ex.material(material)
+	mesh := &export_mesh{material: material, vertices: make(map[export_vertex]int32)}
+	ex.current.meshes = append(ex.current.meshes, mesh)
+	return mesh
+}
+
+// Walk object, adding every surface it contains to the current object.
+func (ex *exporter) add(object Hittable, state *export_state) {
+	switch obj := object.(type) {
+	case *Hit_List:
+		for _, child := range obj.list {
+			ex.add(child, state)
+		}
+	case *BVH:
+		ex.add(*obj.left, state)
+		if obj.right != nil && obj.right != obj.left {
+			ex.add(*obj.right, state)
+		}
+	case *FlatBVH:
+		for _, child := range obj.objects {
+			ex.add(child, state)
+		}
+	case *KDTree:
+		for _, child := range obj.objects {
+			ex.add(child, state)
+		}
+	case *Grid:
+		for _, child := range obj.unique_objects() {
+			ex.add(child, state)
+		}
+
+	case *Instance:
+		ex.transformed(obj.object, state, obj.matrix, obj.material)
+	case *Transform:
+		ex.transformed(obj.object, state, obj.matrix, nil)
+	case *AnimatedTransform:
+		// Where it is at time 0, like moving spheres.
+		if matrix, _, ok := obj.Matrix(0); ok {
+			ex.transformed(obj.object, state, matrix, nil)
+		}
+	case *Constant:
+		inner := *state
+		inner.material = obj.phase_function
+		ex.add(*obj.boundary, &inner)
+
+	case *Sphere:
+		ex.add_sphere(obj, state)
+	case *Quad:
+		mesh := ex.mesh(state.pick(obj.material))
+		p0, p1, p2, p3 := obj.Q, *obj.Q.Add(&obj.u), *obj.Q.Add(&obj.u).Add(&obj.v), *obj.Q.Add(&obj.v)
+		normal := obj.normal
+		i0 := mesh.vertex(state, &p0, &normal, [2]float64{0, 0})
+		i1 := mesh.vertex(state, &p1, &normal, [2]float64{1, 0})
+		i2 := mesh.vertex(state, &p2, &normal, [2]float64{1, 1})
+		i3 := mesh.vertex(state, &p3, &normal, [2]float64{0, 1})
+		mesh.triangle(state, i0, i1, i2)
+		mesh.triangle(state, i0, i2, i3)
+	case *Triangle:
+		mesh := ex.mesh(state.pick(obj.material))
+		corners := [3]Vec3{obj.Q, *obj.Q.Add(&obj.u), *obj.Q.Add(&obj.v)}
+		normals := [3]Vec3{obj.normal, obj.normal, obj.normal}
+		if obj.normals != nil {
+			normals = *obj.normals
+		}
+		uvs := [3][2]float64{{0, 0}, {1, 0}, {0, 1}}
+		var indices [3]int32
+		for k := range corners {
+			indices[k] = mesh.vertex(state, &corners[k], &normals[k], uvs[k])
+		}
+		mesh.triangle(state, indices[0], indices[1], indices[2])
+	case *MeshTriangle:
+		ex.add_mesh_triangle(obj, state)
+
+	default:
+		// Once for each type, as there may be many of them.
+		if kind := fmt.Sprintf("%T", object); !ex.skipped[kind] {
+			ex.skipped[kind] = true
+			ex.warnings = append(ex.warnings, fmt.Errorf("skipped %s objects, they can't be exported", kind))
+		}
+	}
+}
+
+// Add object placed by matrix inside the current transform.
+func (ex *exporter) transformed(object Hittable, state *export_state, matrix Mat4, material *Material) {
+	inverse, ok := matrix.Inverse()
+	if !ok {
+		return // Flattened to nothing
+	}
+	inner := export_state{state.matrix.Mul(&matrix), inverse.Mul(&state.inverse), state.material}
+	if material != nil && inner.material == nil {
+		inner.material = material
+	}
+	ex.add(object, &inner)
+}
+
+// Moving spheres are exported where they are at time 0.
+func (ex *exporter) add_sphere(sphere *Sphere, state *export_state) {
+	mesh := ex.mesh(state.pick(sphere.material))
+
+	// Rows of vertices from the bottom pole to the top one, laid out to match get_sphere_uv.
+	var rows [export_sphere_rings + 1][export_sphere_segments + 1]int32
+	for i := 0; i <= export_sphere_rings; i++ {
+		v := float64(i) / export_sphere_rings
+		theta := v * math.Pi
+		for j := 0; j <= export_sphere_segments; j++ {
+			u := float64(j) / export_sphere_segments
+			phi := u*2*math.Pi - math.Pi
+			normal := *NewVec3(math.Sin(theta)*math.Cos(phi), -math.Cos(theta), -math.Sin(theta)*math.Sin(phi))
+			point := *sphere.center.Add(normal.Scale(sphere.radius))
+			rows[i][j] = mesh.vertex(state, &point, &normal, [2]float64{u, v})
+		}
+	}
+
+	// The triangles touching the poles would have a zero length edge, so those rows only get one triangle per segment.
+	for i := 0; i < export_sphere_rings; i++ {
+		for j := 0; j < export_sphere_segments; j++ {
+			a, b, c, d := rows[i][j], rows[i][j+1], rows[i+1][j+1], rows[i+1][j]
+			if i != 0 {
+				mesh.triangle(state, a, b, c)
+			}
+			if i != export_sphere_rings-1 {
+				mesh.triangle(state, a, c, d)
+			}
+		}
+	}
+}
+
+func (ex *exporter) add_mesh_triangle(tri *MeshTriangle, state *export_state) {
+	mesh := ex.mesh(state.pick(tri.material()))
+	p0, p1, p2 := tri.vertices()
+	corners := [3]*Vec3{p0, p1, p2}
+
+	face := Cross(p1.Sub(p0), p2.Sub(p0))
+	if !face.near_zero() {
+		face = face.Unit()
+	}
+	barycentric := [3][2]float64{{0, 0}, {1, 0}, {0, 1}}
+
+	var indices [3]int32
+	for k, corner := range corners {
+		normal := *face
+		if len(tri.mesh.normals) > 0 {
+			normal = tri.mesh.normals[tri.mesh.normal_indices[3*int(tri.index)+k]]
+		}
+		u, v := tri.uv(barycentric[k][0], barycentric[k][1])
+		indices[k] = mesh.vertex(state, corner, &normal, [2]float64{u, v})
+	}
+	mesh.triangle(state, indices[0], indices[1], indices[2])
+}
+
+// Material to export a surface with, the replacement one if there is one.
+func (state *export_state) pick(material *Material) *Material {
+	if state.material != nil {
+		return state.material
+	}
+	return material
+}
+
+// Index of a vertex in object space, moved to world space.
+func (mesh *export_mesh) vertex(state *export_state, position, normal *Vec3, uv [2]float64) int32 {
+	world_normal := state.inverse.TransformNormal(normal)
+	if !world_normal.near_zero() {
+		world_normal = world_normal.Unit()
+	}
+	vertex := export_vertex{*state.matrix.TransformPoint(position), *world_normal, uv}
+	if index, ok := mesh.vertices[vertex]; ok {
+		return index
+	}
+	index := int32(len(mesh.positions))
+	mesh.positions = append(mesh.positions, vertex.position)
+	mesh.normals = append(mesh.normals, vertex.normal)
+	mesh.uvs = append(mesh.uvs, vertex.uv)
+	mesh.vertices[vertex] = index
+	return index
+}
+
+// Add a triangle wound anticlockwise in object space, which mirroring transforms turn around.
+func (mesh *export_mesh) triangle(state *export_state, a, b, c int32) {
+	if state.matrix.determinant3() < 0 {
+		b, c = c, b
+	}
+	mesh.indices = append(mesh.indices, a, b, c)
+}
+
+// Turn one of our materials into the few properties other formats share. Bump maps are dropped,
+// and textures other than images become the colour at their centre.
+func describe_material(material *Material) export_material {
+	switch mat := (*material).(type) {
+	case *Bump:
+		return describe_material(mat.material)
+	case *Lambert:
+		return describe_texture("diffuse", mat.texture)
+	case *Isotropic:
+		return describe_texture("diffuse", mat.tex)
+	case *Metal:
+		described := describe_texture("metal", mat.texture)
+		described.roughness = mat.fuzz
+		return described
+	case *Dielectric:
+		return export_material{kind: "glass", color: *NewVec3(1, 1, 1), ior: mat.refraction_index}
+	case *DiffuseLight:
+		return export_material{kind: "light", color: (*mat.texture).value(.5, .5, Vec3{})}
+	default:
+		return export_material{kind: "diffuse", color: *NewVec3(.5, .5, .5)}
+	}
+}
+
+func describe_texture(kind string, texture *Texture) export_material {
+	described := export_material{kind: kind, roughness: 1}
+	switch tex := (*texture).(type) {
+	case *Image:
+		described.color, described.image = *NewVec3(1, 1, 1), tex.data
+	case *VertexColor:
+		described.color = tex.fallback
+	default:
+		described.color = tex.value(.5, .5, Vec3{})
+	}
+	return described
+}
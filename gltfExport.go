@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Write a world out as glTF 2.0, a .glb or a .gltf with its buffer embedded, which NewGltf and most 3D tools read.
+// Every top-level object becomes a node with a primitive per material. Glass uses KHR_materials_transmission
+// and KHR_materials_ior, and lights brighter than 1 use KHR_materials_emissive_strength.
+
+// Only what the exporter writes, with empty parts left out.
+type gltf_export_document struct {
+	Asset          gltf_export_asset         `json:"asset"`
+	ExtensionsUsed []string                  `json:"extensionsUsed,omitempty"`
+	Scene          int                       `json:"scene"`
+	Scenes         []gltf_scene              `json:"scenes"`
+	Nodes          []gltf_export_node        `json:"nodes"`
+	Meshes         []gltf_export_mesh        `json:"meshes"`
+	Accessors      []gltf_export_accessor    `json:"accessors"`
+	BufferViews    []gltf_export_buffer_view `json:"bufferViews"`
+	Buffers        []gltf_export_buffer      `json:"buffers"`
+	Materials      []gltf_export_material    `json:"materials"`
+	Textures       []gltf_texture            `json:"textures,omitempty"`
+	Images         []gltf_export_image       `json:"images,omitempty"`
+}
+
+type gltf_export_asset struct {
+	Version   string `json:"version"`
+	Generator string `json:"generator"`
+}
+
+type gltf_export_node struct {
+	Name string `json:"name"`
+	Mesh int    `json:"mesh"`
+}
+
+type gltf_export_mesh struct {
+	Name       string                  `json:"name"`
+	Primitives []gltf_export_primitive `json:"primitives"`
+}
+
+type gltf_export_primitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    int            `json:"indices"`
+	Material   int            `json:"material"`
+}
+
+type gltf_export_accessor struct {
+	BufferView    int       `json:"bufferView"`
+	ComponentType int       `json:"componentType"`
+	Count         int       `json:"count"`
+	Type          string    `json:"type"`
+	Min           []float64 `json:"min,omitempty"`
+	Max           []float64 `json:"max,omitempty"`
+}
+
+type gltf_export_buffer_view struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	Target     int `json:"target,omitempty"`
+}
+
+type gltf_export_buffer struct {
+	URI        string `json:"uri,omitempty"`
+	ByteLength int    `json:"byteLength"`
+}
+
+type gltf_export_image struct {
+	BufferView int    `json:"bufferView"`
+	MimeType   string `json:"mimeType"`
+}
+
+type gltf_export_material struct {
+	Name string `json:"name"`
+	PBR  struct {
+		BaseColorFactor  []float64         `json:"baseColorFactor"`
+		BaseColorTexture *gltf_texture_ref `json:"baseColorTexture,omitempty"`
+		MetallicFactor   float64           `json:"metallicFactor"`
+		RoughnessFactor  float64           `json:"roughnessFactor"`
+	} `json:"pbrMetallicRoughness"`
+	EmissiveFactor []float64      `json:"emissiveFactor,omitempty"`
+	Extensions     map[string]any `json:"extensions,omitempty"`
+}
+
+// Component types and buffer view targets.
+const (
+	gltf_float          = 5126
+	gltf_unsigned_int   = 5125
+	gltf_array_buffer   = 34962
+	gltf_element_buffer = 34963
+)
+
+// Export world to filename, binary if it ends in .glb. The warnings list the objects that were left out.
+func ExportGltf(world Hittable, filename string) (warnings []error, err error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	warnings, err = WriteGltf(world, file, strings.EqualFold(filepath.Ext(filename), ".glb"))
+	if close_err := file.Close(); err == nil {
+		err = close_err
+	}
+	return warnings, err
+}
+
+// Write world as a .glb if binary is set, otherwise as a .gltf with the buffer in a data URI.
+func WriteGltf(world Hittable, writer io.Writer, binary_glb bool) (warnings []error, err error) {
+	ex := export_world(world)
+	doc := gltf_export_document{
+		Asset:  gltf_export_asset{Version: "2.0", Generator: "raytracer"},
+		Scenes: []gltf_scene{{Nodes: []int{}}},
+	}
+	var buffer bytes.Buffer
+
+	// Append data to the buffer as a new view, 4 byte aligned as accessors need.
+	add_view := func(data []byte, target int) int {
+		for buffer.Len()%4 != 0 {
+			buffer.WriteByte(0)
+		}
+		doc.BufferViews = append(doc.BufferViews, gltf_export_buffer_view{ByteOffset: buffer.Len(), ByteLength: len(data), Target: target})
+		buffer.Write(data)
+		return len(doc.BufferViews) - 1
+	}
+	add_accessor := func(data []byte, target, component_type, count int, kind string) int {
+		doc.Accessors = append(doc.Accessors, gltf_export_accessor{
+			BufferView:    add_view(data, target),
+			ComponentType: component_type,
+			Count:         count,
+			Type:          kind,
+		})
+		return len(doc.Accessors) - 1
+	}
+
+	extensions := make(map[string]bool)
+	for _, material := range ex.materials {
+		gltf, err := gltf_export_material_of(&material, &doc, add_view, extensions)
+		if err != nil {
+			return ex.warnings, err
+		}
+		doc.Materials = append(doc.Materials, gltf)
+	}
+	for extension := range extensions {
+		doc.ExtensionsUsed = append(doc.ExtensionsUsed, extension)
+	}
+	sort.Strings(doc.ExtensionsUsed)
+
+	for _, object := range ex.objects {
+		mesh := gltf_export_mesh{Name: object.name}
+		for _, part := range object.meshes {
+			positions := gltf_float32s(len(part.positions)*3, func(i int) float64 { return part.positions[i/3][i%3] })
+			normals := gltf_float32s(len(part.normals)*3, func(i int) float64 { return part.normals[i/3][i%3] })
+			// glTF puts v = 0 at the top of the image.
+			uvs := gltf_float32s(len(part.uvs)*2, func(i int) float64 {
+				if i%2 == 1 {
+					return 1 - part.uvs[i/2][1]
+				}
+				return part.uvs[i/2][0]
+			})
+			indices := make([]byte, 4*len(part.indices))
+			for i, index := range part.indices {
+				binary.LittleEndian.PutUint32(indices[4*i:], uint32(index))
+			}
+
+			position := add_accessor(positions, gltf_array_buffer, gltf_float, len(part.positions), "VEC3")
+			// Positions must say their bounds.
+			bounds := NewEmptyAABB()
+			for i := range part.positions {
+				bounds.IMerge(NewAABB(part.positions[i], part.positions[i]))
+			}
+			doc.Accessors[position].Min = gltf_float32_bounds(bounds.minVec)
+			doc.Accessors[position].Max = gltf_float32_bounds(bounds.maxVec)
+
+			mesh.Primitives = append(mesh.Primitives, gltf_export_primitive{
+				Attributes: map[string]int{
+					"POSITION":   position,
+					"NORMAL":     add_accessor(normals, gltf_array_buffer, gltf_float, len(part.normals), "VEC3"),
+					"TEXCOORD_0": add_accessor(uvs, gltf_array_buffer, gltf_float, len(part.uvs), "VEC2"),
+				},
+				Indices:  add_accessor(indices, gltf_element_buffer, gltf_unsigned_int, len(part.indices), "SCALAR"),
+				Material: ex.names[part.material],
+			})
+		}
+		doc.Meshes = append(doc.Meshes, mesh)
+		doc.Nodes = append(doc.Nodes, gltf_export_node{Name: object.name, Mesh: len(doc.Meshes) - 1})
+		doc.Scenes[0].Nodes = append(doc.Scenes[0].Nodes, len(doc.Nodes)-1)
+	}
+
+	for buffer.Len()%4 != 0 {
+		buffer.WriteByte(0)
+	}
+	doc.Buffers = []gltf_export_buffer{{ByteLength: buffer.Len()}}
+	if !binary_glb {
+		doc.Buffers[0].URI = "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(buffer.Bytes())
+	}
+
+	json_data, err := json.Marshal(&doc)
+	if err != nil {
+		return ex.warnings, err
+	}
+	if !binary_glb {
+		_, err = writer.Write(json_data)
+		return ex.warnings, err
+	}
+
+	// The JSON chunk is padded with spaces, the binary one with zeros.
+	for len(json_data)%4 != 0 {
+		json_data = append(json_data, ' ')
+	}
+	var header [12]byte
+	binary.LittleEndian.PutUint32(header[0:], glb_magic)
+	binary.LittleEndian.PutUint32(header[4:], 2)
+	binary.LittleEndian.PutUint32(header[8:], uint32(12+8+len(json_data)+8+buffer.Len()))
+	var glb bytes.Buffer
+	glb.Write(header[:])
+	for _, chunk := range []struct {
+		kind uint32
+		data []byte
+	}{{glb_chunk_json, json_data}, {glb_chunk_bin, buffer.Bytes()}} {
+		var chunk_header [8]byte
+		binary.LittleEndian.PutUint32(chunk_header[0:], uint32(len(chunk.data)))
+		binary.LittleEndian.PutUint32(chunk_header[4:], chunk.kind)
+		glb.Write(chunk_header[:])
+		glb.Write(chunk.data)
+	}
+	_, err = writer.Write(glb.Bytes())
+	return ex.warnings, err
+}
+
+// Metallic-roughness version of an exported material, the inverse of what NewGltf does with one.
+func gltf_export_material_of(material *export_material, doc *gltf_export_document, add_view func([]byte, int) int, extensions map[string]bool) (gltf_export_material, error) {
+	gltf := gltf_export_material{Name: material.name, Extensions: make(map[string]any)}
+	c := material.color
+	gltf.PBR.BaseColorFactor = []float64{c[0], c[1], c[2], 1}
+	gltf.PBR.RoughnessFactor = 1
+
+	switch material.kind {
+	case "metal":
+		gltf.PBR.MetallicFactor, gltf.PBR.RoughnessFactor = 1, material.roughness
+	case "glass":
+		gltf.PBR.RoughnessFactor = 0
+		gltf.Extensions["KHR_materials_transmission"] = map[string]float64{"transmissionFactor": 1}
+		gltf.Extensions["KHR_materials_ior"] = map[string]float64{"ior": material.ior}
+	case "light":
+		// Emissive factors stop at 1, the rest goes in the strength.
+		gltf.PBR.BaseColorFactor = []float64{0, 0, 0, 1}
+		strength := math.Max(c[0], math.Max(c[1], c[2]))
+		if strength > 1 {
+			gltf.EmissiveFactor = []float64{c[0] / strength, c[1] / strength, c[2] / strength}
+			gltf.Extensions["KHR_materials_emissive_strength"] = map[string]float64{"emissiveStrength": strength}
+		} else {
+			gltf.EmissiveFactor = []float64{c[0], c[1], c[2]}
+		}
+	}
+	if material.image != nil {
+		var encoded bytes.Buffer
+		if err := png.Encode(&encoded, material.image); err != nil {
+			return gltf, fmt.Errorf("%s: %w", material.name, err)
+		}
+		doc.Images = append(doc.Images, gltf_export_image{BufferView: add_view(encoded.Bytes(), 0), MimeType: "image/png"})
+		source := len(doc.Images) - 1
+		doc.Textures = append(doc.Textures, gltf_texture{Source: &source})
+		gltf.PBR.BaseColorTexture = &gltf_texture_ref{Index: len(doc.Textures) - 1}
+	}
+
+	for extension := range gltf.Extensions {
+		extensions[extension] = true
+	}
+	return gltf, nil
+}
+
+// count float32s from value(i), little endian.
+func gltf_float32s(count int, value func(i int) float64) []byte {
+	data := make([]byte, 4*count)
+	for i := 0; i < count; i++ {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(float32(value(i))))
+	}
+	return data
+}
+
+// Bounds as the float32 values actually stored, so they match exactly.
+func gltf_float32_bounds(v Vec3) []float64 {
+	return []float64{float64(float32(v[0])), float64(float32(v[1])), float64(float32(v[2]))}
+}
@@ -0,0 +1,922 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Load glTF 2.0 scenes, .gltf with its buffers and images embedded or beside it, or a single .glb.
+// Meshes are placed by the node hierarchy, metallic-roughness materials are mapped onto ours,
+// perspective and orthographic cameras become views and KHR_lights_punctual lights become point, spot and directional lights.
+
+type gltf_document struct {
+	Scene       *int               `json:"scene"`
+	Scenes      []gltf_scene       `json:"scenes"`
+	Nodes       []gltf_node        `json:"nodes"`
+	Meshes      []gltf_mesh        `json:"meshes"`
+	Accessors   []gltf_accessor    `json:"accessors"`
+	BufferViews []gltf_buffer_view `json:"bufferViews"`
+	Buffers     []gltf_buffer      `json:"buffers"`
+	Materials   []gltf_material    `json:"materials"`
+	Textures    []gltf_texture     `json:"textures"`
+	Images      []gltf_image       `json:"images"`
+	Cameras     []gltf_camera      `json:"cameras"`
+	Extensions  struct {
+		Lights struct {
+			Lights []gltf_light `json:"lights"`
+		} `json:"KHR_lights_punctual"`
+	} `json:"extensions"`
+}
+
+type gltf_scene struct {
+	Nodes []int `json:"nodes"`
+}
+
+type gltf_node struct {
+	Children    []int     `json:"children"`
+	Mesh        *int      `json:"mesh"`
+	Camera      *int      `json:"camera"`
+	Matrix      []float64 `json:"matrix"` // Column major
+	Translation []float64 `json:"translation"`
+	Rotation    []float64 `json:"rotation"` // Quaternion x, y, z, w
+	Scale       []float64 `json:"scale"`
+	Extensions  struct {
+		Light *struct {
+			Light int `json:"light"`
+		} `json:"KHR_lights_punctual"`
+	} `json:"extensions"`
+}
+
+type gltf_mesh struct {
+	Primitives []gltf_primitive `json:"primitives"`
+}
+
+type gltf_primitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    *int           `json:"indices"`
+	Material   *int           `json:"material"`
+	Mode       *int           `json:"mode"`
+}
+
+type gltf_accessor struct {
+	BufferView    *int            `json:"bufferView"`
+	ByteOffset    int             `json:"byteOffset"`
+	ComponentType int             `json:"componentType"`
+	Normalized    bool            `json:"normalized"`
+	Count         int             `json:"count"`
+	Type          string          `json:"type"`
+	Sparse        json.RawMessage `json:"sparse"`
+}
+
+type gltf_buffer_view struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	ByteStride int `json:"byteStride"`
+}
+
+type gltf_buffer struct {
+	URI        string `json:"uri"`
+	ByteLength int    `json:"byteLength"`
+}
+
+type gltf_material struct {
+	PBR struct {
+		BaseColorFactor  []float64         `json:"baseColorFactor"`
+		BaseColorTexture *gltf_texture_ref `json:"baseColorTexture"`
+		MetallicFactor   *float64          `json:"metallicFactor"`
+		RoughnessFactor  *float64          `json:"roughnessFactor"`
+	} `json:"pbrMetallicRoughness"`
+	EmissiveFactor []float64 `json:"emissiveFactor"`
+	Extensions     struct {
+		EmissiveStrength *struct {
+			EmissiveStrength float64 `json:"emissiveStrength"`
+		} `json:"KHR_materials_emissive_strength"`
+		Transmission *struct {
+			TransmissionFactor float64 `json:"transmissionFactor"`
+		} `json:"KHR_materials_transmission"`
+		IOR *struct {
+			IOR *float64 `json:"ior"`
+		} `json:"KHR_materials_ior"`
+	} `json:"extensions"`
+}
+
+type gltf_texture_ref struct {
+	Index int `json:"index"`
+}
+
+type gltf_texture struct {
+	Source *int `json:"source"`
+}
+
+type gltf_image struct {
+	URI        string `json:"uri"`
+	BufferView *int   `json:"bufferView"`
+}
+
+type gltf_camera struct {
+	Type        string `json:"type"`
+	Perspective *struct {
+		AspectRatio float64 `json:"aspectRatio"`
+		YFov        float64 `json:"yfov"`
+	} `json:"perspective"`
+	Orthographic *struct {
+		XMag float64 `json:"xmag"`
+		YMag float64 `json:"ymag"`
+	} `json:"orthographic"`
+}
+
+type gltf_light struct {
+	Type      string    `json:"type"`
+	Color     []float64 `json:"color"`
+	Intensity *float64  `json:"intensity"`
+	Spot      *struct {
+		InnerConeAngle float64  `json:"innerConeAngle"`
+		OuterConeAngle *float64 `json:"outerConeAngle"`
+	} `json:"spot"`
+}
+
+// Everything needed while loading one file.
+type gltf_loader struct {
+	doc       gltf_document
+	dir       string   // External files are relative to this
+	buffers   [][]byte // Contents of each buffer
+	materials map[int]*Material
+	textures  map[int]*Texture
+	instanced map[int]Hittable // Meshes used by several nodes, in their own space
+	warnings  []error          // Passed on to the scene
+}
+
+// A node of the hierarchy with its object to world transform.
+type gltf_placed struct {
+	node   *gltf_node
+	matrix Mat4
+}
+
+// Glue between the chunks of a .glb file.
+const (
+	glb_magic      = 0x46546c67 // "glTF"
+	glb_chunk_json = 0x4e4f534a
+	glb_chunk_bin  = 0x004e4942
+)
+
+// Most elements an accessor without a buffer view can have. Its values are all zero, so nothing in the file limits the count.
+const gltf_max_empty_accessor = 1 << 24
+
+// Load a .gltf or .glb file.
+func NewGltf(filename string) (*Scene, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scene, err := ReadGltf(file, filepath.Dir(filename))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return scene, nil
+}
+
+// Load glTF JSON or a .glb from reader, with any files it refers to relative to dir.
+func ReadGltf(reader io.Reader, dir string) (*Scene, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	loader := gltf_loader{
+		dir:       dir,
+		materials: make(map[int]*Material),
+		textures:  make(map[int]*Texture),
+		instanced: make(map[int]Hittable),
+	}
+
+	json_data, bin := data, []byte(nil)
+	if len(data) >= 12 && binary.LittleEndian.Uint32(data) == glb_magic {
+		if json_data, bin, err = split_glb(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(json_data, &loader.doc); err != nil {
+		return nil, err
+	}
+	if err := loader.load_buffers(bin); err != nil {
+		return nil, err
+	}
+
+	placed, err := loader.place_nodes()
+	if err != nil {
+		return nil, err
+	}
+
+	// Meshes used more than once are shared between instances.
+	uses := make(map[int]int)
+	for _, p := range placed {
+		if p.node.Mesh != nil {
+			uses[*p.node.Mesh]++
+		}
+	}
+
+	scene := Scene{world: NewList()}
+	for _, p := range placed {
+		if p.node.Mesh != nil {
+			if err := loader.add_mesh(&scene, *p.node.Mesh, &p.matrix, uses[*p.node.Mesh] > 1); err != nil {
+				return nil, err
+			}
+		}
+		if p.node.Camera != nil {
+			if err := loader.add_camera(&scene, *p.node.Camera, &p.matrix); err != nil {
+				return nil, err
+			}
+		}
+		if p.node.Extensions.Light != nil {
+			if err := loader.add_light(&scene, p.node.Extensions.Light.Light, &p.matrix); err != nil {
+				return nil, err
+			}
+		}
+	}
+	scene.warnings = loader.warnings
+	return &scene, nil
+}
+
+// Split a .glb into its JSON and its binary buffer, which may be missing.
+func split_glb(data []byte) (json_data, bin []byte, err error) {
+	if version := binary.LittleEndian.Uint32(data[4:]); version != 2 {
+		return nil, nil, fmt.Errorf("glb version %d, only 2 is supported", version)
+	}
+	length := int(binary.LittleEndian.Uint32(data[8:]))
+	if length > len(data) {
+		return nil, nil, fmt.Errorf("glb is %d bytes, its header says %d", len(data), length)
+	}
+
+	for offset := 12; offset+8 <= length; {
+		size := int(binary.LittleEndian.Uint32(data[offset:]))
+		kind := binary.LittleEndian.Uint32(data[offset+4:])
+		start := offset + 8
+		if size < 0 || start+size > length {
+			return nil, nil, fmt.Errorf("glb chunk runs past the end of the file")
+		}
+		switch kind {
+		case glb_chunk_json:
+			json_data = data[start : start+size]
+		case glb_chunk_bin:
+			if bin == nil {
+				bin = data[start : start+size]
+			}
+		}
+		offset = start + size
+	}
+
+	if json_data == nil {
+		return nil, nil, fmt.Errorf("glb has no JSON chunk")
+	}
+	return json_data, bin, nil
+}
+
+func (loader *gltf_loader) load_buffers(bin []byte) error {
+	loader.buffers = make([][]byte, len(loader.doc.Buffers))
+	for i, buffer := range loader.doc.Buffers {
+		var data []byte
+		var err error
+		if buffer.URI == "" {
+			// Only the first buffer of a .glb can be its binary chunk.
+			if i != 0 || bin == nil {
+				return fmt.Errorf("buffer %d has no uri", i)
+			}
+			data = bin
+		} else if data, err = loader.read_uri(buffer.URI); err != nil {
+			return fmt.Errorf("buffer %d: %w", i, err)
+		}
+		if len(data) < buffer.ByteLength {
+			return fmt.Errorf("buffer %d is %d bytes, expected %d", i, len(data), buffer.ByteLength)
+		}
+		loader.buffers[i] = data
+	}
+	return nil
+}
+
+// Contents of a data URI, or of a file relative to the glTF file.
+func (loader *gltf_loader) read_uri(uri string) ([]byte, error) {
+	if strings.HasPrefix(uri, "data:") {
+		comma := strings.IndexByte(uri, ',')
+		if comma < 0 || !strings.HasSuffix(uri[:comma], ";base64") {
+			return nil, fmt.Errorf("only base64 data uris are supported")
+		}
+		return base64.StdEncoding.DecodeString(uri[comma+1:])
+	}
+
+	path, err := url.PathUnescape(uri)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filepath.Join(loader.dir, filepath.FromSlash(path)))
+}
+
+// Walk the node hierarchy of the scene, working out each node's transform.
+func (loader *gltf_loader) place_nodes() ([]gltf_placed, error) {
+	doc := &loader.doc
+
+	var roots []int
+	if len(doc.Scenes) > 0 {
+		scene := 0
+		if doc.Scene != nil {
+			scene = *doc.Scene
+		}
+		if scene < 0 || scene >= len(doc.Scenes) {
+			return nil, fmt.Errorf("scene %d doesn't exist", scene)
+		}
+		roots = doc.Scenes[scene].Nodes
+	} else {
+		// Without scenes, every node that isn't a child is a root.
+		child := make([]bool, len(doc.Nodes))
+		for _, node := range doc.Nodes {
+			for _, c := range node.Children {
+				if c >= 0 && c < len(child) {
+					child[c] = true
+				}
+			}
+		}
+		for i := range doc.Nodes {
+			if !child[i] {
+				roots = append(roots, i)
+			}
+		}
+	}
+
+	var placed []gltf_placed
+	visited := make([]bool, len(doc.Nodes))
+	var visit func(index int, parent *Mat4) error
+	visit = func(index int, parent *Mat4) error {
+		if index < 0 || index >= len(doc.Nodes) {
+			return fmt.Errorf("node %d doesn't exist", index)
+		}
+		// The hierarchy is a forest, so a node is never reached twice.
+		if visited[index] {
+			return fmt.Errorf("node %d has more than one parent", index)
+		}
+		visited[index] = true
+
+		node := &doc.Nodes[index]
+		local, err := node.local_matrix()
+		if err != nil {
+			return fmt.Errorf("node %d: %w", index, err)
+		}
+		matrix := parent.Mul(&local)
+		placed = append(placed, gltf_placed{node, matrix})
+		for _, child := range node.Children {
+			if err := visit(child, &matrix); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	identity := IdentityMat4()
+	for _, root := range roots {
+		if err := visit(root, &identity); err != nil {
+			return nil, err
+		}
+	}
+	return placed, nil
+}
+
+// Node to parent transform, from the column major matrix or translation * rotation * scale.
+func (node *gltf_node) local_matrix() (Mat4, error) {
+	if node.Matrix != nil {
+		if len(node.Matrix) != 16 {
+			return Mat4{}, fmt.Errorf("matrix has %d values", len(node.Matrix))
+		}
+		var matrix Mat4
+		for column := 0; column < 4; column++ {
+			for row := 0; row < 4; row++ {
+				matrix[row][column] = node.Matrix[4*column+row]
+			}
+		}
+		return matrix, nil
+	}
+
+	matrix := IdentityMat4()
+	if node.Translation != nil {
+		if len(node.Translation) != 3 {
+			return Mat4{}, fmt.Errorf("translation has %d values", len(node.Translation))
+		}
+		matrix = TranslationMat4(NewVec3(node.Translation[0], node.Translation[1], node.Translation[2]))
+	}
+	if node.Rotation != nil {
+		if len(node.Rotation) != 4 {
+			return Mat4{}, fmt.Errorf("rotation has %d values", len(node.Rotation))
+		}
+		r := node.Rotation
+		rotation := QuaternionMat4(r[0], r[1], r[2], r[3])
+		matrix = matrix.Mul(&rotation)
+	}
+	if node.Scale != nil {
+		if len(node.Scale) != 3 {
+			return Mat4{}, fmt.Errorf("scale has %d values", len(node.Scale))
+		}
+		scale := ScalingMat4(node.Scale[0], node.Scale[1], node.Scale[2])
+		matrix = matrix.Mul(&scale)
+	}
+	return matrix, nil
+}
+
+// Read an accessor as float64s, with the number of components per element.
+// Normalized integers are mapped to [0, 1] or [-1, 1].
+func (loader *gltf_loader) read_accessor(index int) (values []float64, components int, err error) {
+	doc := &loader.doc
+	if index < 0 || index >= len(doc.Accessors) {
+		return nil, 0, fmt.Errorf("accessor %d doesn't exist", index)
+	}
+	accessor := &doc.Accessors[index]
+	if len(accessor.Sparse) > 0 {
+		return nil, 0, fmt.Errorf("accessor %d is sparse, which isn't supported", index)
+	}
+
+	components = map[string]int{"SCALAR": 1, "VEC2": 2, "VEC3": 3, "VEC4": 4, "MAT2": 4, "MAT3": 9, "MAT4": 16}[accessor.Type]
+	size := map[int]int{5120: 1, 5121: 1, 5122: 2, 5123: 2, 5125: 4, 5126: 4}[accessor.ComponentType]
+	if components == 0 || size == 0 || accessor.Count < 0 {
+		return nil, 0, fmt.Errorf("accessor %d has an unknown type", index)
+	}
+
+	// Without a buffer view the values are all zero.
+	if accessor.BufferView == nil || accessor.Count == 0 {
+		if accessor.Count > gltf_max_empty_accessor {
+			return nil, 0, fmt.Errorf("accessor %d has %d elements but no buffer view", index, accessor.Count)
+		}
+		return make([]float64, accessor.Count*components), components, nil
+	}
+	if *accessor.BufferView < 0 || *accessor.BufferView >= len(doc.BufferViews) {
+		return nil, 0, fmt.Errorf("accessor %d: buffer view %d doesn't exist", index, *accessor.BufferView)
+	}
+	view := &doc.BufferViews[*accessor.BufferView]
+	if view.Buffer < 0 || view.Buffer >= len(loader.buffers) {
+		return nil, 0, fmt.Errorf("accessor %d: buffer %d doesn't exist", index, view.Buffer)
+	}
+	data := loader.buffers[view.Buffer]
+
+	// Check the elements fit in the buffer before allocating for them. Every element takes at least a byte
+	// and strides are at most 252 bytes, so the end can't overflow.
+	stride := view.ByteStride
+	if stride == 0 {
+		stride = components * size
+	}
+	if stride < 0 || stride > 252 {
+		return nil, 0, fmt.Errorf("accessor %d: buffer view %d has a stride of %d bytes", index, *accessor.BufferView, stride)
+	}
+	if accessor.Count > len(data) {
+		return nil, 0, fmt.Errorf("accessor %d runs past the end of its buffer", index)
+	}
+	if view.ByteOffset < 0 || accessor.ByteOffset < 0 || view.ByteOffset > len(data) || accessor.ByteOffset > len(data) {
+		return nil, 0, fmt.Errorf("accessor %d runs past the end of its buffer", index)
+	}
+	start := view.ByteOffset + accessor.ByteOffset
+	end := start + (accessor.Count-1)*stride + components*size
+	if end > view.ByteOffset+view.ByteLength || end > len(data) {
+		return nil, 0, fmt.Errorf("accessor %d runs past the end of its buffer", index)
+	}
+	values = make([]float64, accessor.Count*components)
+
+	for element := 0; element < accessor.Count; element++ {
+		for component := 0; component < components; component++ {
+			raw := data[start+element*stride+component*size:]
+			var value float64
+			switch accessor.ComponentType {
+			case 5120:
+				value = float64(int8(raw[0]))
+				if accessor.Normalized {
+					value = math.Max(value/127, -1)
+				}
+			case 5121:
+				value = float64(raw[0])
+				if accessor.Normalized {
+					value /= 255
+				}
+			case 5122:
+				value = float64(int16(binary.LittleEndian.Uint16(raw)))
+				if accessor.Normalized {
+					value = math.Max(value/32767, -1)
+				}
+			case 5123:
+				value = float64(binary.LittleEndian.Uint16(raw))
+				if accessor.Normalized {
+					value /= 65535
+				}
+			case 5125:
+				value = float64(binary.LittleEndian.Uint32(raw))
+			case 5126:
+				value = float64(math.Float32frombits(binary.LittleEndian.Uint32(raw)))
+			}
+			values[element*components+component] = value
+		}
+	}
+	return values, components, nil
+}
+
+// Put a mesh in the world. Meshes used by several nodes are instanced, unless they glow,
+// as lights behind an instance can't be sampled directly. Everything else is moved into world space.
+func (loader *gltf_loader) add_mesh(scene *Scene, index int, matrix *Mat4, shared bool) error {
+	if index < 0 || index >= len(loader.doc.Meshes) {
+		return fmt.Errorf("mesh %d doesn't exist", index)
+	}
+
+	if shared && !loader.emissive(index) {
+		object, ok := loader.instanced[index]
+		if !ok {
+			meshes, err := loader.build_mesh(index, nil)
+			if err != nil {
+				return err
+			}
+			var objects []Hittable
+			for _, mesh := range meshes {
+				objects = append(objects, mesh.Triangles()...)
+			}
+			if len(objects) == 0 {
+				return nil
+			}
+			object = NewSAHBVH(objects, 4, 1, 1).Flatten()
+			loader.instanced[index] = object
+		}
+		scene.world.Add(NewInstance(object, *matrix, nil))
+		return nil
+	}
+
+	meshes, err := loader.build_mesh(index, matrix)
+	if err != nil {
+		return err
+	}
+	for _, mesh := range meshes {
+		if mesh.Len() > 0 {
+			scene.world.Add(mesh.BVH())
+		}
+	}
+	return nil
+}
+
+// Whether any primitive of the mesh has an emissive material.
+func (loader *gltf_loader) emissive(index int) bool {
+	for _, primitive := range loader.doc.Meshes[index].Primitives {
+		if primitive.Material != nil && *primitive.Material >= 0 && *primitive.Material < len(loader.doc.Materials) {
+			material := &loader.doc.Materials[*primitive.Material]
+			if len(material.EmissiveFactor) == 3 && (material.EmissiveFactor[0] > 0 || material.EmissiveFactor[1] > 0 || material.EmissiveFactor[2] > 0) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// A triangle mesh for each primitive of a mesh, transformed into world space by matrix unless it's nil.
+func (loader *gltf_loader) build_mesh(index int, matrix *Mat4) ([]*TriangleMesh, error) {
+	var meshes []*TriangleMesh
+	for p, primitive := range loader.doc.Meshes[index].Primitives {
+		mesh, err := loader.build_primitive(&primitive, matrix)
+		if err != nil {
+			return nil, fmt.Errorf("mesh %d primitive %d: %w", index, p, err)
+		}
+		if mesh != nil {
+			meshes = append(meshes, mesh)
+		}
+	}
+	return meshes, nil
+}
+
+func (loader *gltf_loader) build_primitive(primitive *gltf_primitive, matrix *Mat4) (*TriangleMesh, error) {
+	// Only triangles, strips and fans have surfaces, points and lines are skipped.
+	mode := 4
+	if primitive.Mode != nil {
+		mode = *primitive.Mode
+	}
+	if mode != 4 && mode != 5 && mode != 6 {
+		return nil, nil
+	}
+
+	position, ok := primitive.Attributes["POSITION"]
+	if !ok {
+		return nil, fmt.Errorf("no POSITION attribute")
+	}
+	values, components, err := loader.read_accessor(position)
+	if err != nil {
+		return nil, err
+	}
+	if components != 3 {
+		return nil, fmt.Errorf("POSITION isn't a VEC3")
+	}
+	positions := make([]Vec3, len(values)/3)
+	for i := range positions {
+		positions[i] = *NewVec3(values[3*i], values[3*i+1], values[3*i+2])
+	}
+
+	// Corners in order, either from the index accessor or just the vertices in order.
+	var corners []int32
+	if primitive.Indices != nil {
+		values, components, err := loader.read_accessor(*primitive.Indices)
+		if err != nil {
+			return nil, err
+		}
+		if components != 1 {
+			return nil, fmt.Errorf("indices aren't SCALAR")
+		}
+		corners = make([]int32, len(values))
+		for i, value := range values {
+			if value < 0 || value >= float64(len(positions)) {
+				return nil, fmt.Errorf("index %v out of range", value)
+			}
+			corners[i] = int32(value)
+		}
+	} else {
+		corners = make([]int32, len(positions))
+		for i := range corners {
+			corners[i] = int32(i)
+		}
+	}
+
+	var indices []int32
+	switch mode {
+	case 4:
+		indices = corners[:len(corners)/3*3]
+	case 5:
+		// Every other triangle of a strip is wound backwards.
+		for i := 0; i+2 < len(corners); i++ {
+			if i%2 == 0 {
+				indices = append(indices, corners[i], corners[i+1], corners[i+2])
+			} else {
+				indices = append(indices, corners[i+1], corners[i], corners[i+2])
+			}
+		}
+	case 6:
+		for i := 1; i+1 < len(corners); i++ {
+			indices = append(indices, corners[0], corners[i], corners[i+1])
+		}
+	}
+
+	var normals []Vec3
+	if index, ok := primitive.Attributes["NORMAL"]; ok {
+		values, components, err := loader.read_accessor(index)
+		if err != nil {
+			return nil, err
+		}
+		if components != 3 || len(values) != 3*len(positions) {
+			return nil, fmt.Errorf("NORMAL doesn't match POSITION")
+		}
+		normals = make([]Vec3, len(positions))
+		for i := range normals {
+			normal := NewVec3(values[3*i], values[3*i+1], values[3*i+2])
+			if normal.near_zero() {
+				normal = NewVec3(0, 0, 1)
+			}
+			normals[i] = *normal.Unit()
+		}
+	}
+
+	var uvs [][2]float64
+	if index, ok := primitive.Attributes["TEXCOORD_0"]; ok {
+		values, components, err := loader.read_accessor(index)
+		if err != nil {
+			return nil, err
+		}
+		if components != 2 || len(values) != 2*len(positions) {
+			return nil, fmt.Errorf("TEXCOORD_0 doesn't match POSITION")
+		}
+		// glTF puts v = 0 at the top of the image, we put it at the bottom.
+		uvs = make([][2]float64, len(positions))
+		for i := range uvs {
+			uvs[i] = [2]float64{values[2*i], 1 - values[2*i+1]}
+		}
+	}
+
+	var colors []Vec3
+	if index, ok := primitive.Attributes["COLOR_0"]; ok {
+		values, components, err := loader.read_accessor(index)
+		if err != nil {
+			return nil, err
+		}
+		if (components != 3 && components != 4) || len(values) != components*len(positions) {
+			return nil, fmt.Errorf("COLOR_0 doesn't match POSITION")
+		}
+		colors = make([]Vec3, len(positions))
+		for i := range colors {
+			colors[i] = *NewVec3(values[components*i], values[components*i+1], values[components*i+2])
+		}
+	}
+
+	material, err := loader.material(primitive.Material, len(colors) > 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Everything per vertex is indexed the same way as the positions.
+	mesh := NewTriangleMesh(positions, indices, material)
+	if len(normals) > 0 {
+		mesh.SetNormals(normals, indices)
+	}
+	if len(uvs) > 0 {
+		mesh.SetUVs(uvs, indices)
+	}
+	if len(colors) > 0 {
+		mesh.SetColors(colors)
+	}
+	if matrix != nil && !mesh.Transform(*matrix) {
+		// Flattened to nothing
+		return nil, nil
+	}
+	return mesh, nil
+}
+
+// Pick the closest of our materials. Emissive materials become lights, transmissive ones glass,
+// metallic ones metal with the roughness as fuzz, and the rest Lambert.
+// Without a base colour texture, vertex colours are shown if the primitive has them.
+func (loader *gltf_loader) material(index *int, vertex_colors bool) (*Material, error) {
+	if index == nil {
+		if vertex_colors {
+			return NewLambertTex(NewVertexColorTexture(*NewVec3(.8, .8, .8))), nil
+		}
+		return NewLambert(*NewVec3(.8, .8, .8)), nil
+	}
+	if *index < 0 || *index >= len(loader.doc.Materials) {
+		return nil, fmt.Errorf("material %d doesn't exist", *index)
+	}
+
+	gltf := &loader.doc.Materials[*index]
+	base := *NewVec3(1, 1, 1)
+	if factor := gltf.PBR.BaseColorFactor; len(factor) >= 3 {
+		base = *NewVec3(factor[0], factor[1], factor[2])
+	}
+
+	// Vertex colours make the material particular to the primitive, so it isn't shared.
+	if vertex_colors && gltf.PBR.BaseColorTexture == nil {
+		return NewLambertTex(NewVertexColorTexture(base)), nil
+	}
+	if material, ok := loader.materials[*index]; ok {
+		return material, nil
+	}
+
+	emission := Vec3{}
+	if len(gltf.EmissiveFactor) == 3 {
+		emission = *NewVec3(gltf.EmissiveFactor[0], gltf.EmissiveFactor[1], gltf.EmissiveFactor[2])
+		if strength := gltf.Extensions.EmissiveStrength; strength != nil {
+			emission = *emission.Scale(strength.EmissiveStrength)
+		}
+	}
+	metallic, roughness := 1.0, 1.0
+	if gltf.PBR.MetallicFactor != nil {
+		metallic = *gltf.PBR.MetallicFactor
+	}
+	if gltf.PBR.RoughnessFactor != nil {
+		roughness = *gltf.PBR.RoughnessFactor
+	}
+
+	var material *Material
+	switch {
+	case !emission.near_zero():
+		material = NewDiffuseLightColor(emission)
+	case gltf.Extensions.Transmission != nil && gltf.Extensions.Transmission.TransmissionFactor > 0:
+		ior := 1.5
+		if gltf.Extensions.IOR != nil && gltf.Extensions.IOR.IOR != nil {
+			ior = *gltf.Extensions.IOR.IOR
+		}
+		material = NewDielectric(ior)
+	default:
+		texture := NewSolidTexture(base)
+		if gltf.PBR.BaseColorTexture != nil {
+			image_texture, err := loader.texture(gltf.PBR.BaseColorTexture.Index)
+			if err != nil {
+				return nil, err
+			}
+			if image_texture != nil {
+				texture = image_texture
+			}
+		}
+		if metallic >= 0.5 {
+			material = NewMetalTex(texture, roughness)
+		} else {
+			material = NewLambertTex(texture)
+		}
+	}
+
+	loader.materials[*index] = material
+	return material, nil
+}
+
+// Image texture, nil if its image is in a format we can't decode.
+func (loader *gltf_loader) texture(index int) (*Texture, error) {
+	if texture, ok := loader.textures[index]; ok {
+		return texture, nil
+	}
+	doc := &loader.doc
+	if index < 0 || index >= len(doc.Textures) {
+		return nil, fmt.Errorf("texture %d doesn't exist", index)
+	}
+	source := doc.Textures[index].Source
+	if source == nil {
+		return nil, nil
+	}
+	if *source < 0 || *source >= len(doc.Images) {
+		return nil, fmt.Errorf("image %d doesn't exist", *source)
+	}
+
+	image := &doc.Images[*source]
+	var data []byte
+	if image.BufferView != nil {
+		if *image.BufferView < 0 || *image.BufferView >= len(doc.BufferViews) {
+			return nil, fmt.Errorf("image %d: buffer view %d doesn't exist", *source, *image.BufferView)
+		}
+		view := &doc.BufferViews[*image.BufferView]
+		if view.Buffer < 0 || view.Buffer >= len(loader.buffers) || view.ByteOffset < 0 || view.ByteLength < 0 ||
+			view.ByteOffset+view.ByteLength > len(loader.buffers[view.Buffer]) {
+			return nil, fmt.Errorf("image %d runs past the end of its buffer", *source)
+		}
+		data = loader.buffers[view.Buffer][view.ByteOffset : view.ByteOffset+view.ByteLength]
+	} else {
+		var err error
+		if data, err = loader.read_uri(image.URI); err != nil {
+			return nil, fmt.Errorf("image %d: %w", *source, err)
+		}
+	}
+
+	texture := NewImageTexture(bytes.NewReader(data))
+	if texture == nil {
+		loader.warnings = append(loader.warnings, fmt.Errorf("can't decode image %d, using the base colour", *source))
+	}
+	loader.textures[index] = texture
+	return texture, nil
+}
+
+// Cameras look down their -z axis with +y up.
+func (loader *gltf_loader) add_camera(scene *Scene, index int, matrix *Mat4) error {
+	if index < 0 || index >= len(loader.doc.Cameras) {
+		return fmt.Errorf("camera %d doesn't exist", index)
+	}
+	gltf := &loader.doc.Cameras[index]
+	view := View{
+		lookfrom: *matrix.TransformPoint(NewVec3(0, 0, 0)),
+		lookat:   *matrix.TransformPoint(NewVec3(0, 0, -1)),
+		vup:      *matrix.TransformVector(NewVec3(0, 1, 0)),
+	}
+	switch {
+	case gltf.Type == "perspective" && gltf.Perspective != nil:
+		view.vfov = gltf.Perspective.YFov * 180 / math.Pi
+		view.aspect_ratio = gltf.Perspective.AspectRatio
+	case gltf.Type == "orthographic" && gltf.Orthographic != nil && gltf.Orthographic.YMag > 0:
+		// xmag and ymag are half the width and height seen.
+		view.projection = ProjectionOrthographic
+		view.ortho_height = 2 * gltf.Orthographic.YMag
+		view.aspect_ratio = gltf.Orthographic.XMag / gltf.Orthographic.YMag
+	default:
+		return nil
+	}
+	scene.views = append(scene.views, view)
+	return nil
+}
+
+// Lights shine down their -z axis. Intensities are used as they are, in candela for point and spot lights and lux for directional ones.
+func (loader *gltf_loader) add_light(scene *Scene, index int, matrix *Mat4) error {
+	lights := loader.doc.Extensions.Lights.Lights
+	if index < 0 || index >= len(lights) {
+		return fmt.Errorf("light %d doesn't exist", index)
+	}
+	gltf := &lights[index]
+
+	color := *NewVec3(1, 1, 1)
+	if len(gltf.Color) == 3 {
+		color = *NewVec3(gltf.Color[0], gltf.Color[1], gltf.Color[2])
+	}
+	intensity := 1.0
+	if gltf.Intensity != nil {
+		intensity = *gltf.Intensity
+	}
+	emission := *color.Scale(intensity)
+	position := *matrix.TransformPoint(NewVec3(0, 0, 0))
+	direction := *matrix.TransformVector(NewVec3(0, 0, -1))
+	if direction.near_zero() {
+		direction = *NewVec3(0, 0, -1)
+	}
+
+	switch gltf.Type {
+	case "point":
+		scene.lights = append(scene.lights, NewPointLight(position, emission))
+	case "spot":
+		inner, outer := 0.0, math.Pi/4
+		if gltf.Spot != nil {
+			inner = gltf.Spot.InnerConeAngle
+			if gltf.Spot.OuterConeAngle != nil {
+				outer = *gltf.Spot.OuterConeAngle
+			}
+		}
+		scene.lights = append(scene.lights, NewSpotLight(position, direction, emission, inner*180/math.Pi, outer*180/math.Pi))
+	case "directional":
+		scene.lights = append(scene.lights, NewDirectionalLight(direction, emission))
+	default:
+		return fmt.Errorf("light %d has unknown type %q", index, gltf.Type)
+	}
+	return nil
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"math"
 	"math/rand/v2"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/pkg/profile"
@@ -54,6 +58,7 @@ func bouncing_spheres() {
 	world.Add(NewSphere(*NewVec3(4, 1, 0), 1.0, mat3))
 
 	cam := NewCamera(1200, *NewVec3(13, 2, 3), *NewVec3(0, 0, 0), *NewVec3(0, 1, 0), 20, 16.0/9.0, 10.0, 0.6, *NewVec3(0.7, 0.8, 1.0))
+	cam.background = NewGradientBackground(*NewVec3(1.0, 1.0, 1.0), *NewVec3(0.5, 0.7, 1.0))
 	cam.render(&world, 100, 10)
 
 }
@@ -129,12 +134,12 @@ func simple_light() {
 	)
 
 	difflight := NewDiffuseLightColor(*NewVec3(4, 4, 4))
-	world.Add(
-		NewQuad(NewVec3(3, 1, -2), NewVec3(2, 0, 0), NewVec3(0, 2, 0), difflight),
-		NewSphere(*NewVec3(0, 7, 0), 2, difflight),
-	)
+	quad_light := NewQuad(NewVec3(3, 1, -2), NewVec3(2, 0, 0), NewVec3(0, 2, 0), difflight)
+	sphere_light := NewSphere(*NewVec3(0, 7, 0), 2, difflight)
+	world.Add(quad_light, sphere_light)
 
 	cam := NewCamera(1000, *NewVec3(26, 3, 6), *NewVec3(0, 2, 0), *NewVec3(0, 1, 0), 20, 16.0/9.0, 1, 0, *NewVec3(0, 0, 0))
+	cam.lights = NewLightTree(quad_light, sphere_light)
 	cam.render(&world, 100, 50)
 
 }
@@ -149,14 +154,16 @@ func cornell_box() {
 
 	world.Add(NewQuad(NewVec3(555, 0, 0), NewVec3(0, 555, 0), NewVec3(0, 0, 555), green))
 	world.Add(NewQuad(NewVec3(0, 0, 0), NewVec3(0, 555, 0), NewVec3(0, 0, 555), red))
-	world.Add(NewQuad(NewVec3(343, 554, 332), NewVec3(-130, 0, 0), NewVec3(0, 0, -105), light))
+	light_quad := NewQuad(NewVec3(343, 554, 332), NewVec3(-130, 0, 0), NewVec3(0, 0, -105), light)
+	world.Add(light_quad)
 	world.Add(NewQuad(NewVec3(0, 0, 0), NewVec3(555, 0, 0), NewVec3(0, 0, 555), white))
 	world.Add(NewQuad(NewVec3(555, 555, 555), NewVec3(-555, 0, 0), NewVec3(0, 0, -555), white))
 	world.Add(NewQuad(NewVec3(0, 0, 555), NewVec3(555, 0, 0), NewVec3(0, 555, 0), white))
-	world.Add(*NewTranslate(NewRotate(NewBox(*NewVec3(0, 0, 0), *NewVec3(165, 330, 165), white), 0, 15, 0), NewVec3(265, 0, 295)))
-	world.Add(*NewTranslate(NewRotate(NewBox(*NewVec3(0, 0, 0), *NewVec3(165, 165, 165), white), 0, -18, 0), NewVec3(130, 0, 65)))
+	world.Add(NewTranslate(NewRotate(NewBox(*NewVec3(0, 0, 0), *NewVec3(165, 330, 165), white), 0, 15, 0), NewVec3(265, 0, 295)))
+	world.Add(NewTranslate(NewRotate(NewBox(*NewVec3(0, 0, 0), *NewVec3(165, 165, 165), white), 0, -18, 0), NewVec3(130, 0, 65)))
 
 	cam := NewCamera(600, *NewVec3(278, 278, -800), *NewVec3(278, 278, 0), *NewVec3(0, 1, 0), 40, 1, 1, 0, *NewVec3(0, 0, 0))
+	cam.lights = NewLightTree(light_quad)
 	cam.render(&world, 200, 50)
 }
 
@@ -170,14 +177,18 @@ func cornell_smoke() {
 
 	world.Add(NewQuad(NewVec3(555, 0, 0), NewVec3(0, 555, 0), NewVec3(0, 0, 555), green))
 	world.Add(NewQuad(NewVec3(0, 0, 0), NewVec3(0, 555, 0), NewVec3(0, 0, 555), red))
-	world.Add(NewQuad(NewVec3(113, 554, 127), NewVec3(330, 0, 0), NewVec3(0, 0, 305), light))
+	light_quad := NewQuad(NewVec3(113, 554, 127), NewVec3(330, 0, 0), NewVec3(0, 0, 305), light)
+	world.Add(light_quad)
 	world.Add(NewQuad(NewVec3(0, 555, 0), NewVec3(555, 0, 0), NewVec3(0, 0, 555), white))
 	world.Add(NewQuad(NewVec3(0, 0, 0), NewVec3(555, 0, 0), NewVec3(0, 0, 555), white))
 	world.Add(NewQuad(NewVec3(0, 0, 555), NewVec3(555, 0, 0), NewVec3(0, 555, 0), white))
-	world.Add(NewConstantMediumAlbedo(NewTranslate(NewRotate(NewBox(*NewVec3(0, 0, 0), *NewVec3(165, 330, 165), white), 0, 15, 0), NewVec3(265, 0, 295)), 0.01, *NewVec3(0, 0, 0)))
-	world.Add(NewConstantMediumAlbedo(NewTranslate(NewRotate(NewBox(*NewVec3(0, 0, 0), *NewVec3(165, 165, 165), white), 0, -18, 0), NewVec3(130, 0, 65)), 0.01, *NewVec3(1, 1, 1)))
+	var box1, box2 Hittable = NewTranslate(NewRotate(NewBox(*NewVec3(0, 0, 0), *NewVec3(165, 330, 165), white), 0, 15, 0), NewVec3(265, 0, 295)),
+		NewTranslate(NewRotate(NewBox(*NewVec3(0, 0, 0), *NewVec3(165, 165, 165), white), 0, -18, 0), NewVec3(130, 0, 65))
+	world.Add(NewConstantMediumAlbedo(&box1, 0.01, *NewVec3(0, 0, 0)))
+	world.Add(NewConstantMediumAlbedo(&box2, 0.01, *NewVec3(1, 1, 1)))
 
 	cam := NewCamera(600, *NewVec3(278, 278, -800), *NewVec3(278, 278, 0), *NewVec3(0, 1, 0), 40, 1, 1, 0, *NewVec3(0, 0, 0))
+	cam.lights = NewLightTree(light_quad)
 	cam.render(&world, 200, 50)
 }
 
@@ -205,7 +216,8 @@ func final_scene(image_width, samples_per_pixel, max_depth int) {
 	world.Add(&boxes1)
 
 	light := NewDiffuseLightColor(*NewVec3(7, 7, 7))
-	world.Add(NewQuad(NewVec3(123, 554, 147), NewVec3(300, 0, 0), NewVec3(0, 0, 265), light))
+	light_quad := NewQuad(NewVec3(123, 554, 147), NewVec3(300, 0, 0), NewVec3(0, 0, 265), light)
+	world.Add(light_quad)
 
 	center1 := NewVec3(400, 400, 200)
 	center2 := center1.Add(NewVec3(30, 0, 0))
@@ -234,7 +246,7 @@ func final_scene(image_width, samples_per_pixel, max_depth int) {
 		boxes2.Add(NewSphere(*NewVec3Random(0, 165), 10, white))
 	}
 
-	world.Add(*NewTranslate(
+	world.Add(NewTranslate(
 		NewRotate(
 			&boxes2, 0, 15, 0), NewVec3(-100, 270, 395),
 	),
@@ -250,6 +262,7 @@ func final_scene(image_width, samples_per_pixel, max_depth int) {
 		1,
 		0,
 		*NewVec3(0, 0, 0))
+	cam.lights = NewLightTree(light_quad)
 	cam.render(&world, samples_per_pixel, max_depth)
 
 }
@@ -268,7 +281,17 @@ func teapot() {
 
 	var world Hit_List
 
-	world.Add(NewRotate(NewObj("teapot.obj"), 0, 0, -90))
+	mesh, err := NewObj("teapot.obj")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	print_warnings(mesh.Warnings())
+	if len(mesh.normals) == 0 {
+		mesh.ComputeNormals(60)
+	}
+	bvh := NewSAHBVH(mesh.Triangles(), 4, 1, 1)
+	world.Add(NewRotate(bvh.Flatten(), 0, 0, -90))
 
 	cam := NewCamera(600, *NewVec3(0, 5, -50), *NewVec3(0, 5, 0), *NewVec3(0, 1, 0), 40, 1, 1, 0, *NewVec3(0.7, 0.8, 1.0))
 
@@ -285,7 +308,7 @@ func more_transforms() {
 
 	//Quads
 	world.Add(
-		*NewScale(NewQuad(NewVec3(-2, -2, 0), NewVec3(4, 0, 0), NewVec3(0, 4, 0), back_green), 2, 2, 2),
+		NewScale(NewQuad(NewVec3(-2, -2, 0), NewVec3(4, 0, 0), NewVec3(0, 4, 0), back_green), 2, 2, 2),
 		NewShear(NewQuad(NewVec3(-2, 3, 1), NewVec3(4, 0, 0), NewVec3(0, 0, 4), upper_orange), 0, 1, 0, 0, 0, 0),
 	)
 
@@ -293,12 +316,208 @@ func more_transforms() {
 	cam.render(&world, 100, 50)
 }
 
+func forest() {
+	var world Hit_List
+
+	ground := NewLambert(*NewVec3(0.48, 0.83, 0.53))
+	world.Add(NewQuad(NewVec3(-1000, 0, -1000), NewVec3(2000, 0, 0), NewVec3(0, 0, 2000), ground))
+
+	// One tree, shared by every instance.
+	var tree Hit_List
+	tree.Add(
+		NewBox(*NewVec3(-0.2, 0, -0.2), *NewVec3(0.2, 2, 0.2), NewLambert(*NewVec3(0.4, 0.25, 0.1))),
+		NewSphere(*NewVec3(0, 2.5, 0), 1, NewLambert(*NewVec3(0.1, 0.5, 0.15))),
+	)
+	tree_bvh := NewSAHBVH(tree.list, 1, 1, 1).Flatten()
+
+	autumn := NewLambert(*NewVec3(0.8, 0.4, 0.1))
+	var instances []*Instance
+	for a := -50; a < 50; a++ {
+		for b := -50; b < 50; b++ {
+			offset := NewVec3(float64(a)*4+Random_float64_bounded(-1, 1), 0, float64(b)*4+Random_float64_bounded(-1, 1))
+			size := Random_float64_bounded(0.7, 1.3)
+
+			translate, rotate, scale := TranslationMat4(offset), RotationMat4(0, Random_float64_bounded(0, 360), 0), ScalingMat4(size, size, size)
+			matrix := rotate.Mul(&scale)
+			matrix = translate.Mul(&matrix)
+
+			var material *Material
+			if rand.Float64() < 0.1 {
+				material = autumn
+			}
+			instances = append(instances, NewInstance(tree_bvh, matrix, material))
+		}
+	}
+	world.Add(NewInstanceBVH(instances...))
+
+	cam := NewCamera(800, *NewVec3(0, 15, -60), *NewVec3(0, 0, 0), *NewVec3(0, 1, 0), 40, 16.0/9.0, 1, 0, *NewVec3(0.7, 0.8, 1.0))
+	cam.background = NewGradientBackground(*NewVec3(1.0, 1.0, 1.0), *NewVec3(0.5, 0.7, 1.0))
+	cam.render(&world, 50, 10)
+}
+
+func animation() {
+	ground := NewQuad(NewVec3(-20, 0, -20), NewVec3(40, 0, 0), NewVec3(0, 0, 40), NewLambertTex(NewCheckerFromColor(1, *NewVec3(0.2, 0.3, 0.1), *NewVec3(0.9, 0.9, 0.9))))
+
+	// A ball bouncing twice a second while rolling along x, keyframed in seconds.
+	var keyframes []Keyframe
+	for i := 0; i <= 8; i++ {
+		time := float64(i) / 4
+		height := 0.5
+		if i%2 == 1 {
+			height = 2.5
+		}
+		spin := QuaternionAxisAngle(NewVec3(0, 0, 1), -float64(i)/0.5*180/math.Pi) // Rolling without slipping
+		keyframes = append(keyframes, NewKeyframe(time, NewVec3(-4+float64(i), height, 0), spin, nil))
+	}
+	checker := NewLambertTex(NewCheckerFromColor(0.2, *NewVec3(0.8, 0.1, 0.1), *NewVec3(0.9, 0.9, 0.9)))
+	ball := NewAnimatedTransform(NewSphere(*NewVec3(0, 0, 0), 0.5, checker), keyframes...)
+
+	// The camera pulls back over the two seconds.
+	views := []ViewKeyframe{
+		{0, View{lookfrom: *NewVec3(0, 2, 8), lookat: *NewVec3(0, 1, 0), vup: *NewVec3(0, 1, 0), vfov: 40}},
+		{2, View{lookfrom: *NewVec3(0, 4, 14), lookat: *NewVec3(0, 1, 0), vup: *NewVec3(0, 1, 0), vfov: 40}},
+	}
+
+	anim := NewAnimation(0, 47, 24, func(time float64) (Hittable, *camera) {
+		view := AnimateView(time, views...)
+		cam := view.Camera(400, *NewVec3(0.7, 0.8, 1.0))
+		cam.background = NewGradientBackground(*NewVec3(1.0, 1.0, 1.0), *NewVec3(0.5, 0.7, 1.0))
+		return NewList(ground, ball), cam
+	})
+	if err := anim.Render(".", 50, 10); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func isometric() {
+	var world Hit_List
+
+	ground := NewLambert(*NewVec3(0.8, 0.8, 0.8))
+	world.Add(NewQuad(NewVec3(-10, 0, -10), NewVec3(20, 0, 0), NewVec3(0, 0, 20), ground))
+
+	// A block of buildings on a grid, seen isometrically so parallel edges stay parallel.
+	for a := -3; a <= 3; a++ {
+		for b := -3; b <= 3; b++ {
+			height := Random_float64_bounded(0.5, 4)
+			shade := Random_float64_bounded(0.4, 0.9)
+			corner := NewVec3(float64(a)*2-0.7, 0, float64(b)*2-0.7)
+			world.Add(NewBox(*corner, *corner.Add(NewVec3(1.4, height, 1.4)), NewLambert(*NewVec3(shade, shade*0.9, shade*0.8))))
+		}
+	}
+
+	cam := NewCamera(600, *NewVec3(20, 20, 20), *NewVec3(0, 0, 0), *NewVec3(0, 1, 0), 40, 1, 1, 0, *NewVec3(0.7, 0.8, 1.0))
+	cam.Orthographic(18)
+	cam.accelerator = AccelBVH
+	cam.render(&world, 100, 10)
+}
+
+func panorama() {
+	var world Hit_List
+
+	checker := NewCheckerFromColor(0.32, *NewVec3(0.2, 0.3, 0.1), *NewVec3(0.9, 0.9, 0.9))
+	world.Add(NewSphere(*NewVec3(0, -1000, 0), 1000, NewLambertTex(checker)))
+
+	// A ring of spheres all the way round the camera.
+	for i := 0; i < 12; i++ {
+		angle := float64(i) * math.Pi / 6
+		center := NewVec3(6*math.Cos(angle), 1, 6*math.Sin(angle))
+		if i%3 == 0 {
+			world.Add(NewSphere(*center, 1, NewMetal(*NewVec3(0.8, 0.8, 0.8), 0.05)))
+		} else {
+			world.Add(NewSphere(*center, 1, NewLambert(*NewVec3(rand.Float64(), rand.Float64(), rand.Float64()))))
+		}
+	}
+
+	cam := NewCamera(800, *NewVec3(0, 1, 0), *NewVec3(0, 1, -1), *NewVec3(0, 1, 0), 40, 2, 1, 0, *NewVec3(0.7, 0.8, 1.0))
+	cam.background = NewGradientBackground(*NewVec3(1.0, 1.0, 1.0), *NewVec3(0.5, 0.7, 1.0))
+	cam.Equirectangular()
+	cam.render(&world, 100, 10)
+}
+
+// raytracer turntable [flags] scene output: orbit the camera once around a scene file, saving a GIF or APNG.
+func turntable(args []string) {
+	flags := flag.NewFlagSet("turntable", flag.ExitOnError)
+	frames := flags.Int("frames", 36, "frames in one turn")
+	frame_rate := flags.Float64("fps", 12, "frames per second")
+	width := flags.Int("width", 400, "image width in pixels")
+	samples := flags.Int("spp", 50, "samples per pixel")
+	depth := flags.Int("depth", 10, "maximum bounces")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: raytracer turntable [flags] scene.{gltf,glb,pbrt,obj,ply,stl} output.{gif,png}")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 || *frames < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	scene, err := LoadScene(flags.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	print_warnings(scene.Warnings())
+	anim := scene.Turntable(*frames, *frame_rate, *width, *NewVec3(0.7, 0.8, 1.0))
+	if err := anim.RenderAnimated(flags.Arg(1), *samples, *depth); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// raytracer export scene output: convert a scene file to OBJ+MTL, or glTF if output ends in .gltf or .glb.
+func export_scene(args []string) {
+	if len(args) != 2 {
+		fmt.Println("Usage: raytracer export scene.{gltf,glb,pbrt,obj,ply,stl} output.{obj,gltf,glb}")
+		os.Exit(2)
+	}
+
+	scene, err := LoadScene(args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	print_warnings(scene.Warnings())
+	var warnings []error
+	if strings.EqualFold(filepath.Ext(args[1]), ".obj") {
+		warnings, err = ExportObj(scene.world, args[1])
+	} else {
+		warnings, err = ExportGltf(scene.world, args[1])
+	}
+	print_warnings(warnings)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// Report what a loader or exporter had to skip.
+func print_warnings(warnings []error) {
+	for _, warning := range warnings {
+		fmt.Println("Warning:", warning)
+	}
+}
+
+// Commands run with the rest of the arguments instead of rendering a demo scene.
+var subcommands = map[string]func(args []string){
+	"export":    export_scene,
+	"turntable": turntable,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := subcommands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
 
 	wd, _ := os.Getwd()
 	defer profile.Start(profile.ProfilePath(wd)).Stop()
 	start := time.Now()
-	switch 12 {
+	scene := 12
+	switch scene {
 	case 1:
 		bouncing_spheres()
 	case 2:
@@ -323,6 +542,14 @@ func main() {
 		teapot()
 	case 12:
 		more_transforms()
+	case 13:
+		forest()
+	case 14:
+		animation()
+	case 15:
+		isometric()
+	case 16:
+		panorama()
 	default:
 		final_scene(400, 250, 4)
 	}
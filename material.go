@@ -41,7 +41,7 @@ func (lambert *Lambert) scatter(incident *Ray, hit *Hit, attenuation *Vec3, scat
 	}
 
 	*scattered = NewRay(hit.point, *scatter_direction, incident.time)
-	*attenuation = (*lambert.texture).value(hit.u, hit.v, hit.point)
+	*attenuation = texture_value(lambert.texture, hit)
 
 	return true
 }
@@ -52,13 +52,18 @@ func (lambert *Lambert) emitted(u, v float64, point *Vec3) Vec3 {
 
 // Metal
 type Metal struct {
-	albedo Vec3
-	fuzz   float64
+	texture *Texture
+	fuzz    float64
 }
 
 // NewMetal creates a new Metal material with the given color and fuzz factor
 func NewMetal(albedo Vec3, fuzz float64) *Material {
-	var metal Material = &Metal{albedo, fuzz}
+	var metal Material = &Metal{NewSolidTexture(albedo), fuzz}
+	return &metal
+}
+
+func NewMetalTex(texture *Texture, fuzz float64) *Material {
+	var metal Material = &Metal{texture, fuzz}
 	return &metal
 }
 
@@ -66,7 +71,7 @@ func (metal *Metal) scatter(incident *Ray, hit *Hit, attenuation *Vec3, scattere
 
 	reflected := *Reflect(&incident.direction, &hit.normal).Unit().Add(Random_unit_Vec3().Scale(metal.fuzz)) // Adding some fuzz to make the reflections look fuzzy
 	*scattered = NewRay(hit.point, reflected, incident.time)
-	*attenuation = metal.albedo
+	*attenuation = texture_value(metal.texture, hit)
 	return (Dot(&scattered.direction, &hit.normal) > 0)
 }
 
@@ -124,6 +129,74 @@ func reflectance(cosine float64, refraction_index float64) float64 {
 	return r0 + (1-r0)*math.Pow((1-cosine), 5)
 }
 
+// Bump mapping, perturbs the shading normal of another material with a height map.
+type Bump struct {
+	material *Material
+	height   *Texture // Brightness is height
+	scale    float64
+}
+
+// Step in u and v for finite differences of height maps that aren't images.
+const bump_delta = 0.0005
+
+// Wraps material so its surface looks raised where the height texture is bright.
+func NewBump(material *Material, height *Texture, scale float64) *Material {
+	var bump Material = &Bump{material, height, scale}
+	return &bump
+}
+
+// Bends the hit's normal, so lighting done with the record afterwards sees the bumps too, then scatters off the wrapped material.
+func (bump *Bump) scatter(incident *Ray, hit *Hit, attenuation *Vec3, scattered *Ray) bool {
+	// Work with the outward normal, otherwise bumps look like dents from behind.
+	outward := hit.normal
+	if !hit.front_face {
+		outward = *outward.Negate()
+	}
+
+	dpdu, dpdv := hit.dpdu, hit.dpdv
+	if dpdu.near_zero() || dpdv.near_zero() {
+		dpdu, dpdv = build_onb(&outward)
+	}
+
+	// Central differences a pixel either side, smaller steps would mostly land in the same pixel.
+	du, dv := bump_delta, bump_delta
+	if image, ok := (*bump.height).(*Image); ok && image.width > 0 && image.height > 0 {
+		du, dv = 1/float64(image.width), 1/float64(image.height)
+	}
+	height := func(u, v float64) float64 {
+		value := (*bump.height).value(u, v, hit.point)
+		return value.Luminance()
+	}
+	slope_u := bump.scale * (height(hit.u+du, hit.v) - height(hit.u-du, hit.v)) / (2 * du)
+	slope_v := bump.scale * (height(hit.u, hit.v+dv) - height(hit.u, hit.v-dv)) / (2 * dv)
+
+	normal := Cross(dpdu.Add(outward.Scale(slope_u)), dpdv.Add(outward.Scale(slope_v)))
+	if !normal.near_zero() {
+		normal = normal.Unit()
+		if Dot(normal, &hit.normal) < 0 {
+			normal = normal.Negate()
+		}
+		hit.normal = *normal
+	}
+
+	return (*bump.material).scatter(incident, hit, attenuation, scattered)
+}
+
+func (bump *Bump) emitted(u, v float64, point *Vec3) Vec3 {
+	return (*bump.material).emitted(u, v, point)
+}
+
+// Whether the material scatters like Lambert, and so can have the lights sampled directly.
+func is_diffuse(material *Material) bool {
+	switch m := (*material).(type) {
+	case *Lambert:
+		return true
+	case *Bump:
+		return is_diffuse(m.material)
+	}
+	return false
+}
+
 /**
 Lights
 */
@@ -182,6 +255,6 @@ func (iso *Isotropic) emitted(u, v float64, point *Vec3) Vec3 {
 
 func (iso *Isotropic) scatter(incident *Ray, hit *Hit, attenuation *Vec3, scattered *Ray) bool {
 	*scattered = NewRay(hit.point, *Random_unit_Vec3(), incident.time)
-	*attenuation = (*iso.tex).value(hit.u, hit.v, hit.point)
+	*attenuation = texture_value(iso.tex, hit)
 	return true
 }
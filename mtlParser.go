@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Parse Wavefront MTL material libraries into our materials.
+// Only the parts that map onto them are read: Kd, Ks, Ns, Ni, d (or Tr), Ke, map_Kd and map_Bump.
+
+// What an MTL file says about one material.
+type mtl_properties struct {
+	Kd, Ks, Ke Vec3
+	Ns         float64 // Specular exponent
+	Ni         float64 // Refractive index
+	d          float64 // Opacity
+	map_Kd     string  // Diffuse texture
+	map_Bump   string  // Height map
+	bump_scale float64
+}
+
+// Defaults from the MTL spec, a plain grey diffuse.
+func default_mtl() mtl_properties {
+	return mtl_properties{Kd: *NewVec3(.8, .8, .8), Ni: 1, d: 1, bump_scale: 1}
+}
+
+// Textures loaded for the materials of an MTL file, and the ones that couldn't be.
+type mtl_textures struct {
+	loaded   map[string]*Texture // Textures shared by several materials are only loaded once
+	warnings []error
+}
+
+// Parse the materials of an MTL file by name. Texture paths are relative to the file.
+// Textures that can't be loaded are left out and listed in warnings.
+func NewMtl(filename string) (materials map[string]*Material, warnings []error, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	dir := filepath.Dir(filename)
+	textures := mtl_textures{loaded: make(map[string]*Texture)}
+	materials = make(map[string]*Material)
+
+	var name string
+	var props mtl_properties
+	finish := func() {
+		if name != "" {
+			materials[name] = props.material(dir, &textures)
+		}
+	}
+
+	scanner := bufio.NewScanner(file)
+	for line_number := 1; scanner.Scan(); line_number++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		args := fields[1:]
+		switch fields[0] {
+		case "newmtl":
+			finish()
+			name, props = strings.Join(args, " "), default_mtl()
+		case "Kd":
+			err = parse_mtl_color(args, &props.Kd)
+		case "Ks":
+			err = parse_mtl_color(args, &props.Ks)
+		case "Ke":
+			err = parse_mtl_color(args, &props.Ke)
+		case "Ns":
+			err = parse_mtl_float(args, &props.Ns)
+		case "Ni":
+			err = parse_mtl_float(args, &props.Ni)
+		case "d":
+			err = parse_mtl_float(args, &props.d)
+		case "Tr":
+			var transparency float64
+			err = parse_mtl_float(args, &transparency)
+			props.d = 1 - transparency
+		case "map_Kd":
+			props.map_Kd, _, err = parse_mtl_map(args)
+		case "map_Bump", "map_bump", "bump":
+			var scale float64
+			props.map_Bump, scale, err = parse_mtl_map(args)
+			props.bump_scale = scale
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %v", filename, line_number, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	finish()
+
+	return materials, textures.warnings, nil
+}
+
+// A colour given as r g b, or a single grey value.
+func parse_mtl_color(args []string, color *Vec3) error {
+	if len(args) != 1 && len(args) != 3 {
+		return fmt.Errorf("expected 1 or 3 values, got %d", len(args))
+	}
+	for i := range color {
+		value, err := strconv.ParseFloat(args[min(i, len(args)-1)], 64)
+		if err != nil {
+			return err
+		}
+		color[i] = value
+	}
+	return nil
+}
+
+func parse_mtl_float(args []string, value *float64) (err error) {
+	if len(args) != 1 {
+		return fmt.Errorf("expected 1 value, got %d", len(args))
+	}
+	*value, err = strconv.ParseFloat(args[0], 64)
+	return err
+}
+
+// Texture map statements are options followed by the file name. Only the bump multiplier -bm is used.
+func parse_mtl_map(args []string) (path string, bump_scale float64, err error) {
+	if len(args) == 0 {
+		return "", 0, fmt.Errorf("missing texture file")
+	}
+
+	// Most values each option takes.
+	option_args := map[string]int{
+		"-bm": 1, "-blendu": 1, "-blendv": 1, "-boost": 1, "-cc": 1, "-clamp": 1, "-imfchan": 1, "-texres": 1,
+		"-mm": 2, "-o": 3, "-s": 3, "-t": 3,
+	}
+
+	bump_scale = 1
+	i := 0
+	for i < len(args)-1 && strings.HasPrefix(args[i], "-") {
+		option := args[i]
+		count, known := option_args[option]
+		if !known {
+			return "", 0, fmt.Errorf("unknown texture option %s", option)
+		}
+		i++
+		for taken := 0; taken < count && i < len(args)-1; taken++ {
+			value, err := strconv.ParseFloat(args[i], 64)
+			if err != nil {
+				// on/off and channel names aren't numbers, otherwise the option had fewer values
+				if option == "-clamp" || option == "-blendu" || option == "-blendv" || option == "-imfchan" {
+					i++
+				}
+				break
+			}
+			if option == "-bm" {
+				bump_scale = value
+			}
+			i++
+		}
+	}
+
+	// Exporters on Windows write backslashes, and some file names contain spaces.
+	path = strings.ReplaceAll(strings.Join(args[i:], " "), `\`, "/")
+	return path, bump_scale, nil
+}
+
+// Pick the closest of our materials. Emitters become lights, see-through materials glass,
+// mostly specular ones metal, and the rest Lambert. A height map wraps the result in a Bump.
+func (props *mtl_properties) material(dir string, textures *mtl_textures) *Material {
+	var material *Material
+	switch {
+	case !props.Ke.near_zero():
+		material = NewDiffuseLightColor(props.Ke)
+	case props.d < 1:
+		material = NewDielectric(math.Max(props.Ni, 1))
+	case props.map_Kd == "" && props.Ks.Luminance() > props.Kd.Luminance():
+		// Higher specular exponents are shinier, as with Blinn-Phong roughness
+		material = NewMetal(props.Ks, math.Min(1, math.Sqrt(2/(props.Ns+2))))
+	default:
+		if texture := textures.load(dir, props.map_Kd); texture != nil {
+			material = NewLambertTex(texture)
+		} else {
+			material = NewLambert(props.Kd)
+		}
+	}
+
+	if height := textures.load(dir, props.map_Bump); height != nil {
+		material = NewBump(material, height, props.bump_scale)
+	}
+	return material
+}
+
+// Load an image texture once, nil if there is none or it can't be read.
+func (textures *mtl_textures) load(dir, path string) *Texture {
+	if path == "" {
+		return nil
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	if texture, ok := textures.loaded[path]; ok {
+		return texture
+	}
+
+	var texture *Texture
+	if file, err := os.Open(path); err != nil {
+		textures.warnings = append(textures.warnings, err)
+	} else {
+		if texture = NewImageTexture(file); texture == nil {
+			textures.warnings = append(textures.warnings, fmt.Errorf("%s: can't decode texture", path))
+		}
+		file.Close()
+	}
+	textures.loaded[path] = texture
+	return texture
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Write a world out as a Wavefront OBJ with an MTL library beside it, readable by NewObj and most 3D tools.
+// Image textures are saved as PNGs next to the library.
+
+// Export world to filename, with the materials in the same name ending in .mtl.
+// The warnings list the objects that were left out.
+func ExportObj(world Hittable, filename string) (warnings []error, err error) {
+	ex := export_world(world)
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	mtl_name := base + ".mtl"
+
+	if err := write_export_file(mtl_name, func(writer *bufio.Writer) error {
+		return ex.write_mtl(writer, base)
+	}); err != nil {
+		return ex.warnings, err
+	}
+	return ex.warnings, write_export_file(filename, func(writer *bufio.Writer) error {
+		ex.write_obj(writer, filepath.Base(mtl_name))
+		return nil
+	})
+}
+
+// Create filename and fill it with write.
+func write_export_file(filename string, write func(writer *bufio.Writer) error) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	if err := write(writer); err != nil {
+		file.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (ex *exporter) write_obj(writer *bufio.Writer, mtllib string) {
+	fmt.Fprintf(writer, "mtllib %s\n", mtllib)
+
+	// OBJ indices count from 1 across the whole file.
+	offset := 1
+	for _, object := range ex.objects {
+		fmt.Fprintf(writer, "o %s\n", object.name)
+		for _, mesh := range object.meshes {
+			for _, p := range mesh.positions {
+				fmt.Fprintf(writer, "v %g %g %g\n", p[0], p[1], p[2])
+			}
+			for _, uv := range mesh.uvs {
+				fmt.Fprintf(writer, "vt %g %g\n", uv[0], uv[1])
+			}
+			for _, n := range mesh.normals {
+				fmt.Fprintf(writer, "vn %g %g %g\n", n[0], n[1], n[2])
+			}
+
+			fmt.Fprintf(writer, "usemtl %s\n", ex.materials[ex.names[mesh.material]].name)
+			for i := 0; i < len(mesh.indices); i += 3 {
+				a, b, c := int(mesh.indices[i])+offset, int(mesh.indices[i+1])+offset, int(mesh.indices[i+2])+offset
+				fmt.Fprintf(writer, "f %d/%d/%d %d/%d/%d %d/%d/%d\n", a, a, a, b, b, b, c, c, c)
+			}
+			offset += len(mesh.positions)
+		}
+	}
+}
+
+// Write the materials the way NewMtl reads them back. Image textures are saved as base_<material>.png.
+func (ex *exporter) write_mtl(writer *bufio.Writer, base string) error {
+	for _, material := range ex.materials {
+		fmt.Fprintf(writer, "newmtl %s\n", material.name)
+		c := material.color
+		switch material.kind {
+		case "metal":
+			// Inverse of the roughness NewMtl works out from Ns.
+			shininess := 1000.0
+			if material.roughness > 0 {
+				shininess = math.Min(shininess, 2/(material.roughness*material.roughness)-2)
+			}
+			fmt.Fprintf(writer, "Kd 0 0 0\nKs %g %g %g\nNs %g\nillum 3\n", c[0], c[1], c[2], shininess)
+		case "glass":
+			// Only half transparent, so other tools still show it.
+			fmt.Fprintf(writer, "Kd 1 1 1\nKs 1 1 1\nNi %g\nd 0.5\nillum 7\n", material.ior)
+		case "light":
+			fmt.Fprintf(writer, "Kd 0 0 0\nKe %g %g %g\n", c[0], c[1], c[2])
+		default:
+			fmt.Fprintf(writer, "Kd %g %g %g\nKs 0 0 0\nillum 1\n", c[0], c[1], c[2])
+			if material.image != nil {
+				image_name := base + "_" + material.name + ".png"
+				file, err := os.Create(image_name)
+				if err != nil {
+					return err
+				}
+				err = png.Encode(file, material.image)
+				if close_err := file.Close(); err == nil {
+					err = close_err
+				}
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(writer, "map_Kd %s\n", filepath.Base(image_name))
+			}
+		}
+		fmt.Fprintln(writer)
+	}
+	return nil
+}
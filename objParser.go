@@ -2,123 +2,310 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
-	"unicode"
 )
 
-// Parse Wavefront OBJ files to give a bunch of hittables.
-// For now, it's just vertexes and faces.
+// Parse Wavefront OBJ files to give a triangle mesh.
+// Vertexes, normals, texture coordinates, faces, groups and materials are read, anything else (lines, curves, smoothing groups) is skipped.
 
 type Parser struct {
-	vertCoord []Vec3
-	vertCount int
-	list      Hit_List
+	vertCoord      []Vec3
+	indices        []int32 // Three vertex indices per triangle
+	normals        []Vec3
+	normal_indices []int32 // Three normal indices per triangle, if every face gave them
+	uvs            [][2]float64
+	uv_indices     []int32 // Three texture coordinate indices per triangle, if every face gave them
+	groups         []mesh_group
+
+	mtllib           func(name string) (map[string]*Material, []error, error) // Loads MTL files, nil to ignore mtllib and usemtl
+	library          map[string]*Material                                     // Materials from the mtllib files by name
+	materials        []*Material                                              // Materials used so far, the first is for faces before any usemtl
+	material_slots   map[string]int32                                         // Index into materials by name
+	current          int32                                                    // Index into materials of the current usemtl
+	material_indices []int32                                                  // Material of each triangle
+	warnings         []error                                                  // Problems with the MTL files that didn't stop loading
+}
+
+// Parse an OBJ file with the materials from its MTL files. Faces without one are green.
+// MTL files that don't exist are skipped, so the model still loads, and listed in the mesh's Warnings.
+func NewObj(filename string) (*TriangleMesh, error) {
+	dir := filepath.Dir(filename)
+	mtllib := func(name string) (map[string]*Material, []error, error) {
+		materials, warnings, err := NewMtl(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, []error{err}, nil
+		}
+		return materials, warnings, err
+	}
+	return open_obj(filename, NewLambert(*NewVec3(.12, .45, .15)), mtllib)
 }
 
-func NewObj(filename string) *Hit_List {
+// Parse an OBJ file giving every face the same material, e.g. a DiffuseLight for an emissive mesh.
+func NewObjMaterial(filename string, material *Material) (*TriangleMesh, error) {
+	return open_obj(filename, material, nil)
+}
+
+func open_obj(filename string, material *Material, mtllib func(name string) (map[string]*Material, []error, error)) (*TriangleMesh, error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return nil, err
 	}
-
 	defer file.Close()
 
-	reader := bufio.NewReader(file)
+	mesh, err := ReadObj(file, material, mtllib)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return mesh, nil
+}
 
-	var parser Parser
+// Parse OBJ data into a mesh. Faces get material unless a usemtl names one from a library loaded with mtllib,
+// which is given the file name from each mtllib statement and returns the library, any warnings about it, and an error if
+// loading should stop. A nil mtllib gives every face material. Errors say which line they are on.
+func ReadObj(reader io.Reader, material *Material, mtllib func(name string) (map[string]*Material, []error, error)) (*TriangleMesh, error) {
+	parser := Parser{
+		mtllib:         mtllib,
+		library:        make(map[string]*Material),
+		materials:      []*Material{material},
+		material_slots: make(map[string]int32),
+	}
 
-	for {
-		line, err := reader.ReadString('\n')
+	buffered := bufio.NewReader(reader)
+	for line_number := 1; ; line_number++ {
+		line, err := buffered.ReadString('\n')
+		// The last line may not end in a newline, it still counts.
+		if len(line) > 0 {
+			if err := parser.parse_line(line); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line_number, err)
+			}
+		}
 		if err == io.EOF {
-			// Last line?
 			break
-		} else {
-			parser.parseVertexLine(line)
+		} else if err != nil {
+			return nil, err
 		}
-
 	}
 
-	return &parser.list
+	mesh := NewTriangleMesh(parser.vertCoord, parser.indices, material)
 
+	// Only use the file's normals if every face has them, partly shaded meshes are left flat.
+	if len(parser.normal_indices) == len(parser.indices) && len(parser.normals) > 0 {
+		mesh.SetNormals(parser.normals, parser.normal_indices)
+	}
+	if len(parser.uv_indices) == len(parser.indices) && len(parser.uvs) > 0 {
+		mesh.SetUVs(parser.uvs, parser.uv_indices)
+	}
+	if len(parser.materials) > 1 {
+		mesh.SetMaterials(parser.materials, parser.material_indices)
+	}
+	mesh.groups = parser.finish_groups()
+	mesh.warnings = parser.warnings
+	return mesh, nil
 }
 
-// Parse the line, see if it's only a vertex or a face.
-func (parser *Parser) parseVertexLine(line string) {
-	line = strings.TrimSpace(line)
-	if strings.HasPrefix(line, "v ") {
-		temp := line[2:]
-		list := strings.FieldsFunc(temp, unicode.IsSpace)
-		result := make([]float64, len(list))
-		for idx, str := range list {
-			str = strings.TrimSpace(str)
-			var err error
-			if result[idx], err = strconv.ParseFloat(str, 64); err != nil {
-				continue
-			}
+// Parse one line of the file. Comments run from # to the end of the line.
+func (parser *Parser) parse_line(line string) error {
+	if comment := strings.IndexByte(line, '#'); comment >= 0 {
+		line = line[:comment]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	args := fields[1:]
+	switch fields[0] {
+	case "v":
+		values, err := parse_obj_floats(args)
+		if err != nil {
+			return err
 		}
-		switch len(result) {
-		case 3:
-			parser.vertCoord = append(parser.vertCoord, *NewVec3(result[0], result[1], result[2]))
+		// x y z, x y z w, or x y z r g b with a colour we don't use.
+		switch len(values) {
+		case 3, 6:
+			parser.vertCoord = append(parser.vertCoord, *NewVec3(values[0], values[1], values[2]))
 		case 4:
-			w := result[3]
-			parser.vertCoord = append(parser.vertCoord, *NewVec3(result[0]/w, result[1]/w, result[2]/w))
+			w := values[3]
+			if w == 0 {
+				return fmt.Errorf("vertex has a w of 0")
+			}
+			parser.vertCoord = append(parser.vertCoord, *NewVec3(values[0]/w, values[1]/w, values[2]/w))
 		default:
-			fmt.Println("Malformed Vertex line:", line)
-			os.Exit(1)
-		}
-
-	} else if strings.HasPrefix(line, "f ") {
-		temp := line[2:]
-		list := strings.FieldsFunc(temp, unicode.IsSpace)
-		result := make([]string, len(list))
-		for idx, str := range list {
-			str = strings.TrimSpace(str)
-			result[idx] = str
-		}
-		size := len(result)
-
-		if size < 3 {
-			fmt.Println("Malformed face line:", line)
-			os.Exit(1)
-		} else if size == 3 {
-			triangle_incides := make([]int, 3)
-			for idx, str := range result {
-				index := strings.Split(str, "/")[0]
-				if number, err := strconv.ParseInt(index, 0, 64); err != nil {
-					fmt.Println(err)
-					os.Exit(1)
-				} else {
-					triangle_incides[idx] = int(number - 1)
-				}
+			return fmt.Errorf("vertex has %d values, expected 3, 4 or 6", len(values))
+		}
+
+	case "vn":
+		values, err := parse_obj_floats(args)
+		if err != nil {
+			return err
+		}
+		if len(values) != 3 {
+			return fmt.Errorf("normal has %d values, expected 3", len(values))
+		}
+		normal := *NewVec3(values[0], values[1], values[2])
+		if normal.near_zero() {
+			normal = *NewVec3(0, 0, 1)
+		}
+		parser.normals = append(parser.normals, *normal.Unit())
+
+	case "vt":
+		// The v and w coordinates are optional, w is ignored.
+		values, err := parse_obj_floats(args)
+		if err != nil {
+			return err
+		}
+		if len(values) < 1 || len(values) > 3 {
+			return fmt.Errorf("texture coordinate has %d values, expected 1 to 3", len(values))
+		}
+		var uv [2]float64
+		copy(uv[:], values)
+		parser.uvs = append(parser.uvs, uv)
+
+	case "f":
+		return parser.parse_face(args)
+
+	case "o", "g":
+		parser.groups = append(parser.groups, mesh_group{name: strings.Join(args, " "), start: len(parser.indices) / 3})
+
+	case "mtllib":
+		if parser.mtllib == nil {
+			return nil
+		}
+		// Several libraries may be listed, file names can't have spaces here.
+		for _, name := range args {
+			library, warnings, err := parser.mtllib(name)
+			if err != nil {
+				return err
 			}
-			vert1, vert2, vert3 := parser.vertCoord[triangle_incides[0]], parser.vertCoord[triangle_incides[1]], parser.vertCoord[triangle_incides[2]]
-
-			parser.list.Add(NewTriangle(&vert1, vert2.Sub(&vert1), vert3.Sub(&vert1), NewLambert(*NewVec3(.12, .45, .15))))
-		} else {
-			// Decompose into a bunch of triangles
-
-			triangle_incides := make([]int, size)
-			for idx, str := range result {
-				index := strings.Split(str, "/")[0]
-				if number, err := strconv.ParseInt(index, 0, 64); err != nil {
-					fmt.Println(err)
-					os.Exit(1)
-				} else {
-					triangle_incides[idx] = int(number - 1)
-				}
+			parser.warnings = append(parser.warnings, warnings...)
+			for name, material := range library {
+				parser.library[name] = material
 			}
-			vert1 := parser.vertCoord[triangle_incides[0]]
-			for index := 1; index < size-1; index++ {
-				parser.list.Add(NewTriangle(&vert1, parser.vertCoord[triangle_incides[index]].Sub(&vert1), parser.vertCoord[triangle_incides[index+1]].Sub(&vert1), NewLambert(*NewVec3(.12, .45, .15))))
+		}
+
+	case "usemtl":
+		if parser.mtllib == nil {
+			return nil
+		}
+		// Materials missing from the libraries keep the default.
+		name := strings.Join(args, " ")
+		slot, ok := parser.material_slots[name]
+		if !ok {
+			material, found := parser.library[name]
+			if !found {
+				material = parser.materials[0]
 			}
+			slot = int32(len(parser.materials))
+			parser.materials = append(parser.materials, material)
+			parser.material_slots[name] = slot
+		}
+		parser.current = slot
+	}
+
+	return nil
+}
+
+// Parse a face and split it into a fan of triangles. Each corner is v, v/vt, v//vn or v/vt/vn.
+func (parser *Parser) parse_face(corners []string) error {
+	size := len(corners)
+	if size < 3 {
+		return fmt.Errorf("face has %d vertices, expected at least 3", size)
+	}
 
+	vertex_indices := make([]int32, size)
+	uv_indices := make([]int32, 0, size)
+	normal_indices := make([]int32, 0, size)
+	for idx, corner := range corners {
+		parts := strings.Split(corner, "/")
+		if len(parts) > 3 {
+			return fmt.Errorf("face vertex %q has too many parts", corner)
+		}
+
+		var err error
+		if vertex_indices[idx], err = parse_obj_index(parts[0], len(parser.vertCoord), "vertex"); err != nil {
+			return err
+		}
+		if len(parts) >= 2 && parts[1] != "" {
+			index, err := parse_obj_index(parts[1], len(parser.uvs), "texture coordinate")
+			if err != nil {
+				return err
+			}
+			uv_indices = append(uv_indices, index)
+		}
+		if len(parts) == 3 && parts[2] != "" {
+			index, err := parse_obj_index(parts[2], len(parser.normals), "normal")
+			if err != nil {
+				return err
+			}
+			normal_indices = append(normal_indices, index)
 		}
 	}
 
-	parser.vertCount++
+	// Decompose into a bunch of triangles
+	for index := 1; index < size-1; index++ {
+		parser.indices = append(parser.indices, vertex_indices[0], vertex_indices[index], vertex_indices[index+1])
+		parser.material_indices = append(parser.material_indices, parser.current)
+		if len(normal_indices) == size {
+			parser.normal_indices = append(parser.normal_indices, normal_indices[0], normal_indices[index], normal_indices[index+1])
+		}
+		if len(uv_indices) == size {
+			parser.uv_indices = append(parser.uv_indices, uv_indices[0], uv_indices[index], uv_indices[index+1])
+		}
+	}
+	return nil
+}
+
+// Turn a one based index into a zero based one. Negative indices count back from the last of the count elements read so far.
+func parse_obj_index(str string, count int, element string) (int32, error) {
+	if str == "" {
+		return 0, fmt.Errorf("missing %s index", element)
+	}
+	number, err := strconv.ParseInt(str, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("bad %s index %q", element, str)
+	}
+
+	index := number - 1
+	if number < 0 {
+		index = int64(count) + number
+	}
+	if number == 0 || index < 0 || index >= int64(count) {
+		return 0, fmt.Errorf("%s index %d out of range, %d read so far", element, number, count)
+	}
+	return int32(index), nil
+}
+
+func parse_obj_floats(args []string) ([]float64, error) {
+	values := make([]float64, len(args))
+	for i, str := range args {
+		value, err := strconv.ParseFloat(str, 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			return nil, fmt.Errorf("bad number %q", str)
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// Work out how many triangles each group has, dropping those with none.
+func (parser *Parser) finish_groups() []mesh_group {
+	var groups []mesh_group
+	triangles := len(parser.indices) / 3
+	for i, group := range parser.groups {
+		end := triangles
+		if i+1 < len(parser.groups) {
+			end = parser.groups[i+1].start
+		}
+		if group.count = end - group.start; group.count > 0 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
 }
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func read_obj_string(data string) (*TriangleMesh, error) {
+	return ReadObj(strings.NewReader(data), NewLambert(*NewVec3(0.5, 0.5, 0.5)), nil)
+}
+
+func TestReadObj(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		triangles int
+		indices   []int32 // Expected position indices, if given
+		groups    []string
+		err       string // Part of the expected error, empty for none
+	}{
+		{
+			name:      "triangle",
+			data:      "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\n",
+			triangles: 1,
+			indices:   []int32{0, 1, 2},
+		},
+		{
+			name:      "quad is split into a fan",
+			data:      "v 0 0 0\nv 1 0 0\nv 1 1 0\nv 0 1 0\nf 1 2 3 4\n",
+			triangles: 2,
+			indices:   []int32{0, 1, 2, 0, 2, 3},
+		},
+		{
+			name:      "negative indices count back from the last vertex",
+			data:      "v 0 0 0\nv 1 0 0\nv 0 1 0\nv 1 1 0\nf -3 -2 -1\n",
+			triangles: 1,
+			indices:   []int32{1, 2, 3},
+		},
+		{
+			name:      "negative normal and texture indices",
+			data:      "v 0 0 0\nv 1 0 0\nv 0 1 0\nvt 0 0\nvt 1 0\nvt 0 1\nvn 0 0 1\nf 1/-3/-1 2/-2/-1 3/-1/-1\n",
+			triangles: 1,
+			indices:   []int32{0, 1, 2},
+		},
+		{
+			name:      "comments and blank lines",
+			data:      "# a triangle\n\nv 0 0 0 # origin\nv 1 0 0\n   \nv 0 1 0\nf 1 2 3 # the face\n",
+			triangles: 1,
+			indices:   []int32{0, 1, 2},
+		},
+		{
+			name:      "last line without a newline",
+			data:      "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3",
+			triangles: 1,
+			indices:   []int32{0, 1, 2},
+		},
+		{
+			name:      "windows line endings",
+			data:      "v 0 0 0\r\nv 1 0 0\r\nv 0 1 0\r\nf 1 2 3\r\n",
+			triangles: 1,
+		},
+		{
+			name:      "object and group names",
+			data:      "v 0 0 0\nv 1 0 0\nv 0 1 0\no first\nf 1 2 3\ng second part\nf 1 2 3\nf 3 2 1\ng empty\n",
+			triangles: 3,
+			groups:    []string{"first", "second part"},
+		},
+		{
+			name: "out of range vertex index",
+			data: "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 4\n",
+			err:  "line 4: vertex index 4 out of range",
+		},
+		{
+			name: "out of range negative index",
+			data: "v 0 0 0\nv 1 0 0\nv 0 1 0\nf -4 1 2\n",
+			err:  "line 4: vertex index -4 out of range",
+		},
+		{
+			name: "zero index",
+			data: "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 0 1 2\n",
+			err:  "line 4: vertex index 0 out of range",
+		},
+		{
+			name: "vertex used before it is defined",
+			data: "v 0 0 0\nv 1 0 0\nf 1 2 3\nv 0 1 0\n",
+			err:  "line 3: vertex index 3 out of range",
+		},
+		{
+			name: "out of range normal index",
+			data: "v 0 0 0\nv 1 0 0\nv 0 1 0\nvn 0 0 1\nf 1//1 2//2 3//1\n",
+			err:  "line 5: normal index 2 out of range",
+		},
+		{
+			name: "out of range texture index",
+			data: "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1/1 2/1 3/1\n",
+			err:  "line 4: texture coordinate index 1 out of range",
+		},
+		{
+			name: "index too big for 32 bits",
+			data: "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 99999999999\n",
+			err:  "line 4: bad vertex index",
+		},
+		{
+			name: "bad number",
+			data: "v 0 0 0\nv 1 zero 0\n",
+			err:  `line 2: bad number "zero"`,
+		},
+		{
+			name: "NaN coordinate",
+			data: "v 0 0 0\nv NaN 0 0\n",
+			err:  "line 2: bad number",
+		},
+		{
+			name: "too few vertex values",
+			data: "# comment\nv 0 0\n",
+			err:  "line 2: vertex has 2 values",
+		},
+		{
+			name: "face with two corners",
+			data: "v 0 0 0\nv 1 0 0\nf 1 2\n",
+			err:  "line 3: face has 2 vertices",
+		},
+		{
+			name: "corner with too many parts",
+			data: "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1/1/1/1 2 3\n",
+			err:  "line 4: face vertex",
+		},
+		{
+			name: "error on a last line without a newline",
+			data: "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 5",
+			err:  "line 4: vertex index 5 out of range",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mesh, err := read_obj_string(test.data)
+			if test.err != "" {
+				if err == nil {
+					t.Fatalf("expected an error containing %q", test.err)
+				}
+				if !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("error %q doesn't contain %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mesh.Len() != test.triangles {
+				t.Errorf("got %d triangles, expected %d", mesh.Len(), test.triangles)
+			}
+			if test.indices != nil && !equal_int32s(mesh.indices, test.indices) {
+				t.Errorf("got indices %v, expected %v", mesh.indices, test.indices)
+			}
+			if test.groups != nil && strings.Join(mesh.GroupNames(), ",") != strings.Join(test.groups, ",") {
+				t.Errorf("got groups %q, expected %q", mesh.GroupNames(), test.groups)
+			}
+		})
+	}
+}
+
+func TestReadObjGroups(t *testing.T) {
+	mesh, err := read_obj_string("v 0 0 0\nv 1 0 0\nv 0 1 0\nv 1 1 0\ng a\nf 1 2 3\ng b\nf 2 4 3\nf 1 2 4\ng a\nf 1 3 4\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(mesh.Group("a")); got != 2 {
+		t.Errorf("group a has %d triangles, expected 2", got)
+	}
+	if got := len(mesh.Group("b")); got != 2 {
+		t.Errorf("group b has %d triangles, expected 2", got)
+	}
+	if got := len(mesh.Group("c")); got != 0 {
+		t.Errorf("group c has %d triangles, expected none", got)
+	}
+}
+
+func TestReadObjMaterials(t *testing.T) {
+	red, blue := NewLambert(*NewVec3(1, 0, 0)), NewLambert(*NewVec3(0, 0, 1))
+	mtllib := func(name string) (map[string]*Material, []error, error) {
+		switch name {
+		case "colors.mtl":
+			return map[string]*Material{"red": red, "blue": blue}, nil, nil
+		case "missing.mtl":
+			return nil, []error{errors.New("missing.mtl not found")}, nil
+		}
+		return nil, nil, errors.New("unreadable library")
+	}
+	data := "mtllib missing.mtl colors.mtl\nv 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\nusemtl red\nf 1 2 3\nusemtl blue\nf 1 2 3\nusemtl unknown\nf 1 2 3\n"
+	mesh, err := ReadObj(strings.NewReader(data), NewLambert(*NewVec3(0, 1, 0)), mtllib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mesh.Warnings()) != 1 {
+		t.Errorf("got warnings %v, expected the missing library", mesh.Warnings())
+	}
+	expected := []*Material{mesh.material, red, blue, mesh.material}
+	for i := range mesh.triangles {
+		if got := mesh.triangles[i].material(); got != expected[i] {
+			t.Errorf("triangle %d has the wrong material", i)
+		}
+	}
+
+	_, err = ReadObj(strings.NewReader("v 0 0 0\nmtllib broken.mtl\n"), red, mtllib)
+	if err == nil || !strings.Contains(err.Error(), "line 2: unreadable library") {
+		t.Errorf("got error %v, expected the library's error on line 2", err)
+	}
+}
+
+// Whatever the input, ReadObj returns an error or a mesh whose indices are all in range, and never panics.
+func FuzzReadObj(f *testing.F) {
+	seeds := []string{
+		"v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\n",
+		"v 0 0 0\nv 1 0 0\nv 1 1 0\nv 0 1 0\nvt 0 0\nvt 1 1\nvn 0 0 1\nf 1/1/1 2/2/1 3/1/1 4/2/1\n",
+		"v 0 0 0\nv 1 0 0\nv 0 1 0\nf -3 -2 -1",
+		"o a\nv 0 0 0 1\nv 1 0 0 2\nv 0 1 0 0.5\ng b c\nf 1//1 2//1 3//1\n",
+		"mtllib lib.mtl\nusemtl red\nv 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3 # comment\n",
+		"v 1e308 -1e308 0\nv 0 0 0\nv 0 0 0\nf 1 2 3\r\n",
+		"f 1 2 3\n",
+		"vt 0.5\nvn 0 0 0\nv 0 0 0 1 1 1\n",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	material := NewLambert(*NewVec3(0.5, 0.5, 0.5))
+	mtllib := func(name string) (map[string]*Material, []error, error) {
+		return map[string]*Material{"red": material}, nil, nil
+	}
+	f.Fuzz(func(t *testing.T, data string) {
+		mesh, err := ReadObj(strings.NewReader(data), material, mtllib)
+		if err != nil {
+			return
+		}
+		if len(mesh.indices)%3 != 0 || mesh.Len() != len(mesh.indices)/3 {
+			t.Fatalf("%d indices for %d triangles", len(mesh.indices), mesh.Len())
+		}
+		check_indices(t, "position", mesh.indices, len(mesh.positions))
+		if len(mesh.normals) > 0 {
+			check_indices(t, "normal", mesh.normal_indices, len(mesh.normals))
+		}
+		if len(mesh.uvs) > 0 {
+			check_indices(t, "texture coordinate", mesh.uv_indices, len(mesh.uvs))
+		}
+		if len(mesh.materials) > 0 {
+			check_indices(t, "material", mesh.material_indices, len(mesh.materials))
+		}
+		for _, group := range mesh.groups {
+			if group.start < 0 || group.count <= 0 || group.start+group.count > mesh.Len() {
+				t.Fatalf("group %q covers triangles %d to %d of %d", group.name, group.start, group.start+group.count, mesh.Len())
+			}
+		}
+		// Every triangle can be bounded and hit without panicking.
+		for _, triangle := range mesh.Triangles() {
+			triangle.bounding_box()
+			ray := NewRay(*NewVec3(0, 0, -10), *NewVec3(0, 0, 1), 0)
+			var record Hit
+			triangle.hit(&ray, 0.001, 100, &record)
+		}
+	})
+}
+
+func check_indices(t *testing.T, element string, indices []int32, count int) {
+	t.Helper()
+	for _, index := range indices {
+		if index < 0 || int(index) >= count {
+			t.Fatalf("%s index %d out of range, there are %d", element, index, count)
+		}
+	}
+}
+
+func equal_int32s(a, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,719 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Load a subset of the PBRT-v3 scene format, enough to compare renders with pbrt itself.
+// Supported are the transform directives, LookAt and perspective cameras, attribute and object blocks,
+// sphere, trianglemesh and plymesh shapes, matte, metal, mirror and glass materials, diffuse area lights,
+// and point, spot and distant lights. Other directives, cameras, shapes, area lights and lights are skipped,
+// other materials become matte, and each of them is listed in the scene's Warnings.
+
+type pbrt_token struct {
+	text   string
+	line   int
+	quoted bool
+}
+
+// A parameter such as "float radius" [2], with the values as numbers or strings.
+type pbrt_param struct {
+	kind    string
+	numbers []float64
+	strings []string
+}
+
+type pbrt_params map[string]pbrt_param
+
+// Graphics state saved by AttributeBegin.
+type pbrt_attributes struct {
+	ctm        Mat4      // Current transformation matrix, object to world
+	material   *Material // Material for new shapes
+	area_light *Material // Emitting material replacing material while set
+}
+
+type pbrt_loader struct {
+	dir        string // Included and PLY files are relative to this
+	attributes pbrt_attributes
+	stack      []pbrt_attributes
+	transforms []Mat4 // Saved by TransformBegin
+
+	named_materials map[string]*Material
+	objects         map[string][]Hittable // Shapes of each ObjectBegin block, in object space
+	object          string                // Object being defined, if inside ObjectBegin
+	in_object       bool
+
+	camera_from_world *Mat4
+	fov               float64
+	aspect_ratio      float64
+
+	line      int             // Line of the directive being read, for warnings
+	including map[string]bool // Files being read by Include, so one including itself is caught
+	scene     Scene
+}
+
+// Load a PBRT-v3 scene file.
+func NewPbrt(filename string) (*Scene, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scene, err := ReadPbrt(file, filepath.Dir(filename))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return scene, nil
+}
+
+// Load a PBRT-v3 scene from reader, with included and PLY files relative to dir.
+func ReadPbrt(reader io.Reader, dir string) (*Scene, error) {
+	loader := pbrt_loader{
+		dir:             dir,
+		attributes:      pbrt_attributes{ctm: IdentityMat4(), material: NewLambert(*NewVec3(.5, .5, .5))},
+		named_materials: make(map[string]*Material),
+		objects:         make(map[string][]Hittable),
+		including:       make(map[string]bool),
+		fov:             90,
+		aspect_ratio:    1280.0 / 720.0,
+		scene:           Scene{world: NewList()},
+	}
+	if err := loader.parse(reader); err != nil {
+		return nil, err
+	}
+
+	if loader.camera_from_world != nil {
+		view, err := loader.view()
+		if err != nil {
+			return nil, err
+		}
+		loader.scene.views = append(loader.scene.views, view)
+	}
+	return &loader.scene, nil
+}
+
+// Note something skipped in the scene's warnings, with the line it's on.
+func (loader *pbrt_loader) warn(format string, args ...any) {
+	loader.scene.warnings = append(loader.scene.warnings, fmt.Errorf("line %d: "+format, append([]any{loader.line}, args...)...))
+}
+
+func (loader *pbrt_loader) parse(reader io.Reader) error {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	tokens, err := tokenize_pbrt(string(data))
+	if err != nil {
+		return err
+	}
+
+	// Each directive is a bare word followed by everything up to the next one.
+	for i := 0; i < len(tokens); {
+		directive := tokens[i]
+		if directive.quoted || is_pbrt_value(directive.text) {
+			return fmt.Errorf("line %d: expected a directive, found %q", directive.line, directive.text)
+		}
+		end := i + 1
+		for end < len(tokens) && (tokens[end].quoted || is_pbrt_value(tokens[end].text)) {
+			end++
+		}
+		loader.line = directive.line
+		if err := loader.directive(directive.text, tokens[i+1:end]); err != nil {
+			return fmt.Errorf("line %d: %s: %w", directive.line, directive.text, err)
+		}
+		i = end
+	}
+	return nil
+}
+
+// Split the file into words, quoted strings and brackets, dropping comments.
+func tokenize_pbrt(data string) ([]pbrt_token, error) {
+	var tokens []pbrt_token
+	line := 1
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+		case c == '[' || c == ']':
+			tokens = append(tokens, pbrt_token{text: string(c), line: line})
+			i++
+		case c == '"':
+			end := strings.IndexByte(data[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			text := data[i+1 : i+1+end]
+			tokens = append(tokens, pbrt_token{text: text, line: line, quoted: true})
+			line += strings.Count(text, "\n")
+			i += end + 2
+		default:
+			start := i
+			for i < len(data) && !strings.ContainsRune(" \t\r\n[]\"#", rune(data[i])) {
+				i++
+			}
+			tokens = append(tokens, pbrt_token{text: data[start:i], line: line})
+		}
+	}
+	return tokens, nil
+}
+
+// Numbers and brackets belong to the directive before them.
+func is_pbrt_value(text string) bool {
+	if text == "[" || text == "]" {
+		return true
+	}
+	_, err := strconv.ParseFloat(text, 64)
+	return err == nil
+}
+
+func (loader *pbrt_loader) directive(name string, args []pbrt_token) error {
+	attributes := &loader.attributes
+	switch name {
+	case "Identity":
+		attributes.ctm = IdentityMat4()
+	case "Translate":
+		values, err := pbrt_numbers(args, 3)
+		if err != nil {
+			return err
+		}
+		translation := TranslationMat4(NewVec3(values[0], values[1], values[2]))
+		attributes.ctm = attributes.ctm.Mul(&translation)
+	case "Scale":
+		values, err := pbrt_numbers(args, 3)
+		if err != nil {
+			return err
+		}
+		scale := ScalingMat4(values[0], values[1], values[2])
+		attributes.ctm = attributes.ctm.Mul(&scale)
+	case "Rotate":
+		values, err := pbrt_numbers(args, 4)
+		if err != nil {
+			return err
+		}
+		axis := NewVec3(values[1], values[2], values[3])
+		if axis.near_zero() {
+			return fmt.Errorf("rotation axis is zero")
+		}
+		rotation := AxisAngleMat4(axis, values[0])
+		attributes.ctm = attributes.ctm.Mul(&rotation)
+	case "LookAt":
+		values, err := pbrt_numbers(args, 9)
+		if err != nil {
+			return err
+		}
+		look_at, err := pbrt_look_at(NewVec3(values[0], values[1], values[2]), NewVec3(values[3], values[4], values[5]), NewVec3(values[6], values[7], values[8]))
+		if err != nil {
+			return err
+		}
+		attributes.ctm = attributes.ctm.Mul(&look_at)
+	case "Transform", "ConcatTransform":
+		values, err := pbrt_numbers(args, 16)
+		if err != nil {
+			return err
+		}
+		// The values are column by column.
+		var matrix Mat4
+		for column := 0; column < 4; column++ {
+			for row := 0; row < 4; row++ {
+				matrix[row][column] = values[4*column+row]
+			}
+		}
+		if name == "Transform" {
+			attributes.ctm = matrix
+		} else {
+			attributes.ctm = attributes.ctm.Mul(&matrix)
+		}
+
+	case "AttributeBegin":
+		loader.stack = append(loader.stack, *attributes)
+	case "AttributeEnd":
+		if len(loader.stack) == 0 {
+			return fmt.Errorf("no matching AttributeBegin")
+		}
+		*attributes = loader.stack[len(loader.stack)-1]
+		loader.stack = loader.stack[:len(loader.stack)-1]
+	case "TransformBegin":
+		loader.transforms = append(loader.transforms, attributes.ctm)
+	case "TransformEnd":
+		if len(loader.transforms) == 0 {
+			return fmt.Errorf("no matching TransformBegin")
+		}
+		attributes.ctm = loader.transforms[len(loader.transforms)-1]
+		loader.transforms = loader.transforms[:len(loader.transforms)-1]
+	case "WorldBegin":
+		attributes.ctm = IdentityMat4()
+
+	case "Camera":
+		kind, params, err := pbrt_parse_params(args)
+		if err != nil {
+			return err
+		}
+		if kind != "perspective" {
+			loader.warn("skipping unsupported camera %q", kind)
+			return nil
+		}
+		camera_from_world := attributes.ctm
+		loader.camera_from_world = &camera_from_world
+		loader.fov = params.float("fov", 90)
+	case "Film":
+		_, params, err := pbrt_parse_params(args)
+		if err != nil {
+			return err
+		}
+		width, height := params.float("xresolution", 1280), params.float("yresolution", 720)
+		if width > 0 && height > 0 {
+			loader.aspect_ratio = width / height
+		}
+
+	case "Material":
+		kind, params, err := pbrt_parse_params(args)
+		if err != nil {
+			return err
+		}
+		attributes.material = loader.make_material(kind, params)
+	case "MakeNamedMaterial":
+		name, params, err := pbrt_parse_params(args)
+		if err != nil {
+			return err
+		}
+		loader.named_materials[name] = loader.make_material(params.string("type", "matte"), params)
+	case "NamedMaterial":
+		name, _, err := pbrt_parse_params(args)
+		if err != nil {
+			return err
+		}
+		material, ok := loader.named_materials[name]
+		if !ok {
+			return fmt.Errorf("no material named %q", name)
+		}
+		attributes.material = material
+	case "AreaLightSource":
+		kind, params, err := pbrt_parse_params(args)
+		if err != nil {
+			return err
+		}
+		if kind != "diffuse" {
+			loader.warn("skipping unsupported area light %q", kind)
+			attributes.area_light = nil
+			return nil
+		}
+		emission, scale := params.rgb("L", *NewVec3(1, 1, 1)), params.rgb("scale", *NewVec3(1, 1, 1))
+		attributes.area_light = NewDiffuseLightColor(*emission.Mult(&scale))
+	case "LightSource":
+		kind, params, err := pbrt_parse_params(args)
+		if err != nil {
+			return err
+		}
+		return loader.light(kind, params)
+
+	case "Shape":
+		kind, params, err := pbrt_parse_params(args)
+		if err != nil {
+			return err
+		}
+		return loader.shape(kind, params)
+	case "ObjectBegin":
+		name, _, err := pbrt_parse_params(args)
+		if err != nil {
+			return err
+		}
+		loader.stack = append(loader.stack, *attributes)
+		loader.object, loader.in_object = name, true
+		loader.objects[name] = nil
+	case "ObjectEnd":
+		if !loader.in_object || len(loader.stack) == 0 {
+			return fmt.Errorf("no matching ObjectBegin")
+		}
+		*attributes = loader.stack[len(loader.stack)-1]
+		loader.stack = loader.stack[:len(loader.stack)-1]
+		loader.in_object = false
+	case "ObjectInstance":
+		name, _, err := pbrt_parse_params(args)
+		if err != nil {
+			return err
+		}
+		objects, ok := loader.objects[name]
+		if !ok {
+			return fmt.Errorf("no object named %q", name)
+		}
+		if len(objects) > 0 {
+			loader.scene.world.Add(NewInstance(NewSAHBVH(objects, 4, 1, 1).Flatten(), attributes.ctm, nil))
+		}
+
+	case "Include":
+		name, _, err := pbrt_parse_params(args)
+		if err != nil {
+			return err
+		}
+		path := filepath.Clean(filepath.Join(loader.dir, name))
+		if loader.including[path] {
+			return fmt.Errorf("%s is already being included, it includes itself", name)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		loader.including[path] = true
+		err = loader.parse(file)
+		delete(loader.including, path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+	case "Sampler", "Integrator", "PixelFilter", "Accelerator", "WorldEnd":
+		// Settings for pbrt's own renderer, which don't change the scene.
+	default:
+		loader.warn("skipping unsupported directive %s", name)
+	}
+	return nil
+}
+
+// Exactly count numbers, in brackets or not.
+func pbrt_numbers(args []pbrt_token, count int) ([]float64, error) {
+	var values []float64
+	for _, arg := range args {
+		if arg.text == "[" || arg.text == "]" {
+			continue
+		}
+		value, err := strconv.ParseFloat(arg.text, 64)
+		if arg.quoted || err != nil {
+			return nil, fmt.Errorf("bad number %q", arg.text)
+		}
+		values = append(values, value)
+	}
+	if len(values) != count {
+		return nil, fmt.Errorf("expected %d numbers, got %d", count, len(values))
+	}
+	return values, nil
+}
+
+// Split the arguments into the leading name or type and the "type name" value parameters after it.
+func pbrt_parse_params(args []pbrt_token) (name string, params pbrt_params, err error) {
+	if len(args) == 0 || !args[0].quoted {
+		return "", nil, fmt.Errorf("missing name")
+	}
+	name = args[0].text
+	params = make(pbrt_params)
+
+	for i := 1; i < len(args); {
+		declaration := strings.Fields(args[i].text)
+		if !args[i].quoted || len(declaration) != 2 {
+			return "", nil, fmt.Errorf("expected a parameter declaration, found %q", args[i].text)
+		}
+		i++
+
+		// One value, or a list in brackets.
+		var values []pbrt_token
+		if i < len(args) && args[i].text == "[" && !args[i].quoted {
+			i++
+			for i < len(args) && !(args[i].text == "]" && !args[i].quoted) {
+				values = append(values, args[i])
+				i++
+			}
+			if i == len(args) {
+				return "", nil, fmt.Errorf("unterminated list for %q", declaration[1])
+			}
+			i++
+		} else if i < len(args) {
+			values = append(values, args[i])
+			i++
+		}
+
+		param := pbrt_param{kind: declaration[0]}
+		for _, value := range values {
+			if value.quoted {
+				param.strings = append(param.strings, value.text)
+			} else if number, err := strconv.ParseFloat(value.text, 64); err == nil {
+				param.numbers = append(param.numbers, number)
+			} else {
+				return "", nil, fmt.Errorf("bad value %q for %q", value.text, declaration[1])
+			}
+		}
+		params[declaration[1]] = param
+	}
+	return name, params, nil
+}
+
+func (params pbrt_params) float(name string, fallback float64) float64 {
+	if param, ok := params[name]; ok && len(param.numbers) > 0 {
+		return param.numbers[0]
+	}
+	return fallback
+}
+
+func (params pbrt_params) string(name string, fallback string) string {
+	if param, ok := params[name]; ok && len(param.strings) > 0 {
+		return param.strings[0]
+	}
+	return fallback
+}
+
+func (params pbrt_params) point(name string, fallback Vec3) Vec3 {
+	if param, ok := params[name]; ok && len(param.numbers) == 3 {
+		return *NewVec3(param.numbers[0], param.numbers[1], param.numbers[2])
+	}
+	return fallback
+}
+
+// A colour given as rgb, or as a spectrum of wavelength and value pairs, which is averaged to a grey.
+// Blackbody and spectrum files aren't supported and give fallback.
+func (params pbrt_params) rgb(name string, fallback Vec3) Vec3 {
+	param, ok := params[name]
+	if !ok {
+		return fallback
+	}
+	switch param.kind {
+	case "rgb", "color":
+		if len(param.numbers) == 3 {
+			return *NewVec3(param.numbers[0], param.numbers[1], param.numbers[2])
+		}
+	case "float":
+		if len(param.numbers) == 1 {
+			return *NewVec3(param.numbers[0], param.numbers[0], param.numbers[0])
+		}
+	case "spectrum":
+		if len(param.numbers) >= 2 && len(param.numbers)%2 == 0 {
+			sum := 0.0
+			for i := 1; i < len(param.numbers); i += 2 {
+				sum += param.numbers[i]
+			}
+			grey := sum / float64(len(param.numbers)/2)
+			return *NewVec3(grey, grey, grey)
+		}
+	}
+	return fallback
+}
+
+// Every value as float64, or nil if it isn't there.
+func (params pbrt_params) floats(name string) []float64 {
+	return params[name].numbers
+}
+
+// Pick the closest of our materials. Materials we don't have become Lambert with their Kd.
+func (loader *pbrt_loader) make_material(kind string, params pbrt_params) *Material {
+	switch kind {
+	case "metal":
+		// Reflectance at normal incidence from the complex refractive index, copper by default.
+		eta := params.rgb("eta", *NewVec3(0.2004, 0.9240, 1.1022))
+		k := params.rgb("k", *NewVec3(3.9129, 2.4528, 2.1421))
+		var reflectance Vec3
+		for i := range reflectance {
+			reflectance[i] = ((eta[i]-1)*(eta[i]-1) + k[i]*k[i]) / ((eta[i]+1)*(eta[i]+1) + k[i]*k[i])
+		}
+		return NewMetal(reflectance, math.Min(1, params.float("roughness", 0.01)))
+	case "mirror":
+		return NewMetal(params.rgb("Kr", *NewVec3(.9, .9, .9)), 0)
+	case "glass":
+		return NewDielectric(params.float("index", params.float("eta", 1.5)))
+	case "matte":
+	default:
+		loader.warn("unsupported material %q, using matte", kind)
+	}
+	return NewLambert(params.rgb("Kd", *NewVec3(.5, .5, .5)))
+}
+
+// Material for new shapes, emissive if inside an area light.
+func (loader *pbrt_loader) material() *Material {
+	if loader.attributes.area_light != nil {
+		return loader.attributes.area_light
+	}
+	return loader.attributes.material
+}
+
+func (loader *pbrt_loader) shape(kind string, params pbrt_params) error {
+	ctm := loader.attributes.ctm
+	var object Hittable
+	switch kind {
+	case "sphere":
+		radius := params.float("radius", 1)
+		if scale, ok := similarity_scale(&ctm); ok {
+			object = NewSphere(*ctm.TransformPoint(NewVec3(0, 0, 0)), radius*scale, loader.material())
+		} else {
+			// Squashed spheres go behind a transform.
+			object = NewInstance(NewSphere(*NewVec3(0, 0, 0), radius, loader.material()), ctm, nil)
+		}
+
+	case "trianglemesh":
+		mesh, err := pbrt_triangle_mesh(params, loader.material())
+		if err != nil {
+			return err
+		}
+		if mesh.Len() == 0 || !mesh.Transform(ctm) {
+			return nil
+		}
+		object = mesh.BVH()
+
+	case "plymesh":
+		filename := params.string("filename", "")
+		file, err := os.Open(filepath.Join(loader.dir, filename))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		mesh, err := ReadPly(file, loader.material())
+		if err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+		if mesh.Len() == 0 || !mesh.Transform(ctm) {
+			return nil
+		}
+		object = mesh.BVH()
+
+	default:
+		loader.warn("skipping unsupported shape %q", kind)
+		return nil
+	}
+
+	if loader.in_object {
+		loader.objects[loader.object] = append(loader.objects[loader.object], object)
+	} else {
+		loader.scene.world.Add(object)
+	}
+	return nil
+}
+
+// Build a trianglemesh shape's mesh in object space.
+func pbrt_triangle_mesh(params pbrt_params, material *Material) (*TriangleMesh, error) {
+	points := params.floats("P")
+	if len(points) == 0 || len(points)%3 != 0 {
+		return nil, fmt.Errorf("trianglemesh needs P as a list of points")
+	}
+	positions := make([]Vec3, len(points)/3)
+	for i := range positions {
+		positions[i] = *NewVec3(points[3*i], points[3*i+1], points[3*i+2])
+	}
+
+	// Indices may be left out for a single triangle.
+	values := params.floats("indices")
+	if values == nil && len(positions) == 3 {
+		values = []float64{0, 1, 2}
+	}
+	if len(values)%3 != 0 {
+		return nil, fmt.Errorf("trianglemesh indices aren't a multiple of 3")
+	}
+	indices := make([]int32, len(values))
+	for i, value := range values {
+		if value < 0 || value >= float64(len(positions)) || value != math.Trunc(value) {
+			return nil, fmt.Errorf("trianglemesh index %v out of range", value)
+		}
+		indices[i] = int32(value)
+	}
+
+	mesh := NewTriangleMesh(positions, indices, material)
+	if normals := params.floats("N"); len(normals) == len(points) {
+		vertex_normals := make([]Vec3, len(positions))
+		for i := range vertex_normals {
+			normal := NewVec3(normals[3*i], normals[3*i+1], normals[3*i+2])
+			if normal.near_zero() {
+				normal = NewVec3(0, 0, 1)
+			}
+			vertex_normals[i] = *normal.Unit()
+		}
+		mesh.SetNormals(vertex_normals, indices)
+	}
+	uv := params.floats("uv")
+	if uv == nil {
+		uv = params.floats("st")
+	}
+	if len(uv) == 2*len(positions) {
+		uvs := make([][2]float64, len(positions))
+		for i := range uvs {
+			uvs[i] = [2]float64{uv[2*i], uv[2*i+1]}
+		}
+		mesh.SetUVs(uvs, indices)
+	}
+	return mesh, nil
+}
+
+func (loader *pbrt_loader) light(kind string, params pbrt_params) error {
+	ctm := &loader.attributes.ctm
+	scale := params.rgb("scale", *NewVec3(1, 1, 1))
+	from := params.point("from", *NewVec3(0, 0, 0))
+	position := *ctm.TransformPoint(&from)
+
+	switch kind {
+	case "point":
+		intensity := params.rgb("I", *NewVec3(1, 1, 1))
+		loader.scene.lights = append(loader.scene.lights, NewPointLight(position, *intensity.Mult(&scale)))
+	case "spot":
+		to := params.point("to", *NewVec3(0, 0, 1))
+		direction := ctm.TransformVector(to.Sub(&from))
+		cone_angle, cone_delta := params.float("coneangle", 30), params.float("conedelta", 5)
+		intensity := params.rgb("I", *NewVec3(1, 1, 1))
+		loader.scene.lights = append(loader.scene.lights, NewSpotLight(position, *direction, *intensity.Mult(&scale), cone_angle-cone_delta, cone_angle))
+	case "distant":
+		to := params.point("to", *NewVec3(0, 0, 1))
+		direction := ctm.TransformVector(to.Sub(&from))
+		irradiance := params.rgb("L", *NewVec3(1, 1, 1))
+		loader.scene.lights = append(loader.scene.lights, NewDirectionalLight(*direction, *irradiance.Mult(&scale)))
+	default:
+		loader.warn("skipping unsupported light %q", kind)
+	}
+	return nil
+}
+
+// World to camera matrix of PBRT's LookAt. Its cameras look down +z with +x to the right, which makes it left-handed.
+func pbrt_look_at(position, look, up *Vec3) (Mat4, error) {
+	direction := look.Sub(position)
+	if direction.near_zero() {
+		return Mat4{}, fmt.Errorf("camera looks at its own position")
+	}
+	direction = direction.Unit()
+	right := Cross(up.Unit(), direction)
+	if right.near_zero() {
+		return Mat4{}, fmt.Errorf("up vector is parallel to the viewing direction")
+	}
+	right = right.Unit()
+	new_up := Cross(direction, right)
+
+	camera_to_world := Mat4{
+		{right[0], new_up[0], direction[0], position[0]},
+		{right[1], new_up[1], direction[1], position[1]},
+		{right[2], new_up[2], direction[2], position[2]},
+		{0, 0, 0, 1},
+	}
+	world_to_camera, _ := camera_to_world.Inverse()
+	return world_to_camera, nil
+}
+
+// Work out our view from PBRT's camera. Its fov is across the shorter side of the image.
+func (loader *pbrt_loader) view() (View, error) {
+	camera_to_world, ok := loader.camera_from_world.Inverse()
+	if !ok {
+		return View{}, fmt.Errorf("camera transform is singular")
+	}
+
+	view := View{
+		lookfrom:     *camera_to_world.TransformPoint(NewVec3(0, 0, 0)),
+		lookat:       *camera_to_world.TransformPoint(NewVec3(0, 0, 1)),
+		vup:          *camera_to_world.TransformVector(NewVec3(0, 1, 0)),
+		vfov:         loader.fov,
+		aspect_ratio: loader.aspect_ratio,
+	}
+	if loader.aspect_ratio < 1 {
+		half := math.Tan(loader.fov / 2 * math.Pi / 180)
+		view.vfov = 2 * math.Atan(half/loader.aspect_ratio) * 180 / math.Pi
+	}
+
+	// Our cameras have Cross(vup, lookfrom - lookat) to the right, if PBRT's right is the other way the image is mirrored.
+	right := camera_to_world.TransformVector(NewVec3(1, 0, 0))
+	backwards := view.lookfrom.Sub(&view.lookat)
+	view.mirrored = Dot(right, Cross(&view.vup, backwards)) < 0
+	return view, nil
+}
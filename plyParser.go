@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Parse Stanford PLY files, ASCII or binary in either byte order, to give a triangle mesh.
+// Vertex positions, normals, texture coordinates and colours are read along with the faces. Other elements are skipped.
+
+type ply_property struct {
+	name       string
+	kind       string // Type of the value, or of the list items
+	count_kind string // Type of the list length, empty if it's not a list
+}
+
+type ply_element struct {
+	name       string
+	count      int
+	properties []ply_property
+}
+
+// Size in bytes of each PLY type in binary files.
+var ply_sizes = map[string]int{
+	"char": 1, "int8": 1, "uchar": 1, "uint8": 1,
+	"short": 2, "int16": 2, "ushort": 2, "uint16": 2,
+	"int": 4, "int32": 4, "uint": 4, "uint32": 4, "float": 4, "float32": 4,
+	"double": 8, "float64": 8,
+}
+
+// Parse a PLY file. Meshes with vertex colours show them, the rest are green.
+func NewPly(filename string) (*TriangleMesh, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mesh, err := ReadPly(file, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return mesh, nil
+}
+
+// Parse PLY data into a mesh with the given material. A nil material shows the vertex colours if there are any, and is green otherwise.
+func ReadPly(reader io.Reader, material *Material) (*TriangleMesh, error) {
+	buffered := bufio.NewReader(reader)
+	format, elements, err := read_ply_header(buffered)
+	if err != nil {
+		return nil, err
+	}
+
+	// Values come from either words of text or bytes in the file's order.
+	var next func(kind string) (float64, error)
+	switch format {
+	case "ascii":
+		words := bufio.NewScanner(buffered)
+		words.Split(bufio.ScanWords)
+		next = func(kind string) (float64, error) {
+			if !words.Scan() {
+				if err := words.Err(); err != nil {
+					return 0, err
+				}
+				return 0, io.ErrUnexpectedEOF
+			}
+			return strconv.ParseFloat(words.Text(), 64)
+		}
+	case "binary_little_endian":
+		next = ply_binary_reader(buffered, binary.LittleEndian)
+	case "binary_big_endian":
+		next = ply_binary_reader(buffered, binary.BigEndian)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+
+	var positions, normals, colors []Vec3
+	var uvs [][2]float64
+	var indices []int32
+	for _, element := range elements {
+		// Where each property we use is in the element, -1 if it isn't there.
+		find := func(names ...string) int {
+			for i, property := range element.properties {
+				for _, name := range names {
+					if property.name == name {
+						return i
+					}
+				}
+			}
+			return -1
+		}
+		x, y, z := find("x"), find("y"), find("z")
+		nx, ny, nz := find("nx"), find("ny"), find("nz")
+		red, green, blue := find("red", "r", "diffuse_red"), find("green", "g", "diffuse_green"), find("blue", "b", "diffuse_blue")
+		u, v := find("u", "s", "texture_u", "texture_s"), find("v", "t", "texture_v", "texture_t")
+		face := find("vertex_indices", "vertex_index")
+
+		has_normals := nx >= 0 && ny >= 0 && nz >= 0
+		has_colors := red >= 0 && green >= 0 && blue >= 0
+		has_uvs := u >= 0 && v >= 0
+		if element.name == "vertex" && (x < 0 || y < 0 || z < 0) {
+			return nil, fmt.Errorf("vertex element has no x, y and z")
+		}
+
+		values := make([]float64, len(element.properties))
+		var list []int32
+		for item := 0; item < element.count; item++ {
+			for i, property := range element.properties {
+				if property.count_kind == "" {
+					if values[i], err = next(property.kind); err != nil {
+						return nil, fmt.Errorf("%s %d: %w", element.name, item, err)
+					}
+					continue
+				}
+
+				length, err := next(property.count_kind)
+				if err != nil || length < 0 || length != math.Trunc(length) {
+					return nil, fmt.Errorf("%s %d: bad list length", element.name, item)
+				}
+				list = list[:0]
+				for j := 0; j < int(length); j++ {
+					value, err := next(property.kind)
+					if err != nil {
+						return nil, fmt.Errorf("%s %d: %w", element.name, item, err)
+					}
+					if i == face {
+						if value < 0 || value >= float64(len(positions)) {
+							return nil, fmt.Errorf("face %d: vertex index %v out of range", item, value)
+						}
+						list = append(list, int32(value))
+					}
+				}
+			}
+
+			switch element.name {
+			case "vertex":
+				positions = append(positions, *NewVec3(values[x], values[y], values[z]))
+				if has_normals {
+					normal := NewVec3(values[nx], values[ny], values[nz])
+					if normal.near_zero() {
+						normal = NewVec3(0, 0, 1)
+					}
+					normals = append(normals, *normal.Unit())
+				}
+				if has_colors {
+					color := NewVec3(values[red], values[green], values[blue])
+					// Integer colours go up to 255, floating point ones up to 1.
+					if kind := element.properties[red].kind; kind != "float" && kind != "float32" && kind != "double" && kind != "float64" {
+						color = color.Scale(1.0 / 255)
+					}
+					colors = append(colors, *color)
+				}
+				if has_uvs {
+					uvs = append(uvs, [2]float64{values[u], values[v]})
+				}
+			case "face":
+				if face < 0 {
+					return nil, fmt.Errorf("face element has no vertex_indices")
+				}
+				// Decompose into a bunch of triangles
+				for j := 1; j+1 < len(list); j++ {
+					indices = append(indices, list[0], list[j], list[j+1])
+				}
+			}
+		}
+	}
+
+	if material == nil {
+		if len(colors) > 0 {
+			material = NewLambertTex(NewVertexColorTexture(*NewVec3(.12, .45, .15)))
+		} else {
+			material = NewLambert(*NewVec3(.12, .45, .15))
+		}
+	}
+
+	// Everything per vertex is indexed the same way as the positions.
+	mesh := NewTriangleMesh(positions, indices, material)
+	if len(normals) > 0 {
+		mesh.SetNormals(normals, indices)
+	}
+	if len(uvs) > 0 {
+		mesh.SetUVs(uvs, indices)
+	}
+	if len(colors) > 0 {
+		mesh.SetColors(colors)
+	}
+	return mesh, nil
+}
+
+// Read the header up to end_header, giving the format and the elements in the order they appear.
+func read_ply_header(reader *bufio.Reader) (format string, elements []ply_element, err error) {
+	for line_number := 1; ; line_number++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", nil, fmt.Errorf("header ends early: %w", err)
+		}
+		fields := strings.Fields(line)
+		if line_number == 1 {
+			if len(fields) != 1 || fields[0] != "ply" {
+				return "", nil, fmt.Errorf("not a PLY file")
+			}
+			continue
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		bad := fmt.Errorf("header line %d: malformed %s", line_number, fields[0])
+		switch fields[0] {
+		case "format":
+			if len(fields) != 3 {
+				return "", nil, bad
+			}
+			format = fields[1]
+		case "element":
+			if len(fields) != 3 {
+				return "", nil, bad
+			}
+			count, err := strconv.Atoi(fields[2])
+			if err != nil || count < 0 {
+				return "", nil, bad
+			}
+			elements = append(elements, ply_element{name: fields[1], count: count})
+		case "property":
+			if len(elements) == 0 {
+				return "", nil, fmt.Errorf("header line %d: property before any element", line_number)
+			}
+			var property ply_property
+			if len(fields) == 5 && fields[1] == "list" {
+				property = ply_property{name: fields[4], kind: fields[3], count_kind: fields[2]}
+			} else if len(fields) == 3 {
+				property = ply_property{name: fields[2], kind: fields[1]}
+			} else {
+				return "", nil, bad
+			}
+			if ply_sizes[property.kind] == 0 || (property.count_kind != "" && ply_sizes[property.count_kind] == 0) {
+				return "", nil, fmt.Errorf("header line %d: unknown type", line_number)
+			}
+			last := &elements[len(elements)-1]
+			last.properties = append(last.properties, property)
+		case "end_header":
+			if format == "" {
+				return "", nil, fmt.Errorf("header has no format")
+			}
+			return format, elements, nil
+		}
+		// comment, obj_info and anything else is skipped
+	}
+}
+
+// Reads binary values of any PLY type as float64.
+func ply_binary_reader(reader io.Reader, order binary.ByteOrder) func(kind string) (float64, error) {
+	var buffer [8]byte
+	return func(kind string) (float64, error) {
+		bytes := buffer[:ply_sizes[kind]]
+		if _, err := io.ReadFull(reader, bytes); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		switch kind {
+		case "char", "int8":
+			return float64(int8(bytes[0])), nil
+		case "uchar", "uint8":
+			return float64(bytes[0]), nil
+		case "short", "int16":
+			return float64(int16(order.Uint16(bytes))), nil
+		case "ushort", "uint16":
+			return float64(order.Uint16(bytes)), nil
+		case "int", "int32":
+			return float64(int32(order.Uint32(bytes))), nil
+		case "uint", "uint32":
+			return float64(order.Uint32(bytes)), nil
+		case "float", "float32":
+			return float64(math.Float32frombits(order.Uint32(bytes))), nil
+		default:
+			return math.Float64frombits(order.Uint64(bytes)), nil
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"math"
+	"math/rand/v2"
 )
 
 // A Sphere
@@ -103,6 +104,65 @@ func (sphere *Sphere) bounding_box() (bounds *AABB) {
 	return &sphere.bbox
 }
 
+// Samples the cone of directions the sphere subtends from origin, or the whole surface if origin is inside it.
+func (sphere *Sphere) sample_light(origin *Vec3, time float64) (direction Vec3, distance float64, emission Vec3, pdf float64) {
+	center := sphere.center
+	if sphere.is_moving {
+		center = sphere.sphere_center(time)
+	}
+
+	to_center := center.Sub(origin)
+	distance_squared := to_center.Length_Squared()
+	radius_squared := sphere.radius * sphere.radius
+
+	var point Vec3
+	if distance_squared <= radius_squared {
+		point = *center.Add(Random_unit_Vec3().Scale(sphere.radius))
+		to_light := point.Sub(origin)
+		distance = to_light.Magnitude()
+		if distance < 1e-8 {
+			return direction, distance, Vec3{}, 0
+		}
+		direction = *to_light.Scale(1 / distance)
+		normal := point.Sub(&center).Scale(1 / sphere.radius)
+		cosine := math.Abs(Dot(&direction, normal))
+		if cosine < 1e-8 {
+			return direction, distance, Vec3{}, 0
+		}
+		pdf = distance * distance / (cosine * 4 * math.Pi * radius_squared)
+	} else {
+		cos_theta_max := math.Sqrt(1 - radius_squared/distance_squared)
+		z := 1 + rand.Float64()*(cos_theta_max-1)
+		phi := 2 * math.Pi * rand.Float64()
+		r := math.Sqrt(math.Max(0, 1-z*z))
+
+		w := to_center.Unit()
+		u, v := build_onb(w)
+		direction = *u.Scale(r * math.Cos(phi)).Add(v.Scale(r * math.Sin(phi))).Add(w.Scale(z))
+
+		// Nearest intersection of the sampled direction with the sphere.
+		h := Dot(&direction, to_center)
+		distance = h - math.Sqrt(math.Max(0, h*h-distance_squared+radius_squared))
+		point = *origin.Add(direction.Scale(distance))
+		pdf = 1 / (2 * math.Pi * (1 - cos_theta_max))
+	}
+
+	u, v := get_sphere_uv(*point.Sub(&center).Scale(1 / sphere.radius))
+	return direction, distance, (*sphere.material).emitted(u, v, &point), pdf
+}
+
+// Spheres have normals in every direction.
+func (sphere *Sphere) light_bounds() LightBounds {
+	emission := (*sphere.material).emitted(0.5, 0.5, &sphere.center)
+	return LightBounds{
+		bbox:        sphere.bbox,
+		w:           *NewVec3(0, 0, 1),
+		phi:         emission.Luminance() * 4 * math.Pi * sphere.radius * sphere.radius,
+		cos_theta_o: -1,
+		cos_theta_e: 0,
+	}
+}
+
 // Get the UV coordinates relative to a sphere given a Vec3.
 // p: a given point on the sphere of radius one, centered at the origin.
 // u: returned value [0,1] of angle around the Y axis from X=-1.
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Parse STL files, ASCII or binary, to give a triangle mesh.
+// STL lists the corners of every triangle separately, so corners at the same position are merged into one vertex.
+// The facet normals are ignored, the winding gives the same thing.
+
+// Parse an STL file giving every face the same material.
+func NewStl(filename string, material *Material) (*TriangleMesh, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mesh, err := ReadStl(file, material)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return mesh, nil
+}
+
+// Parse STL data into a mesh with the given material.
+func ReadStl(reader io.Reader, material *Material) (*TriangleMesh, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// Binary files can start with "solid" too, but only they have a triangle count matching their size.
+	var corners []Vec3
+	if len(data) >= 84 && 84+50*int64(binary.LittleEndian.Uint32(data[80:84])) == int64(len(data)) {
+		corners = read_stl_binary(data)
+	} else if bytes.HasPrefix(bytes.TrimSpace(data), []byte("solid")) {
+		if corners, err = read_stl_ascii(data); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, fmt.Errorf("not an STL file, or a truncated binary one")
+	}
+
+	positions := make([]Vec3, 0, len(corners)/3)
+	indices := make([]int32, len(corners))
+	seen := make(map[Vec3]int32)
+	for i, corner := range corners {
+		index, ok := seen[corner]
+		if !ok {
+			index = int32(len(positions))
+			positions = append(positions, corner)
+			seen[corner] = index
+		}
+		indices[i] = index
+	}
+
+	return NewTriangleMesh(positions, indices, material), nil
+}
+
+// Each triangle is 50 bytes: the normal, three corners, and a two byte attribute.
+func read_stl_binary(data []byte) []Vec3 {
+	count := int(binary.LittleEndian.Uint32(data[80:84]))
+	corners := make([]Vec3, 0, 3*count)
+	for i := 0; i < count; i++ {
+		triangle := data[84+50*i:]
+		for corner := 1; corner <= 3; corner++ {
+			var point Vec3
+			for axis := 0; axis < 3; axis++ {
+				offset := 12*corner + 4*axis
+				point[axis] = float64(math.Float32frombits(binary.LittleEndian.Uint32(triangle[offset : offset+4])))
+			}
+			corners = append(corners, point)
+		}
+	}
+	return corners
+}
+
+// Only the vertex lines matter, every three make a triangle.
+func read_stl_ascii(data []byte) ([]Vec3, error) {
+	var corners []Vec3
+	for line_number, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "vertex":
+			if len(fields) != 4 {
+				return nil, fmt.Errorf("line %d: vertex has %d values, expected 3", line_number+1, len(fields)-1)
+			}
+			var point Vec3
+			for axis := 0; axis < 3; axis++ {
+				value, err := strconv.ParseFloat(fields[axis+1], 64)
+				if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+					return nil, fmt.Errorf("line %d: bad number %q", line_number+1, fields[axis+1])
+				}
+				point[axis] = value
+			}
+			corners = append(corners, point)
+		case "endloop":
+			if len(corners)%3 != 0 {
+				return nil, fmt.Errorf("line %d: facet doesn't have 3 vertices", line_number+1)
+			}
+		}
+	}
+
+	if len(corners)%3 != 0 {
+		return nil, fmt.Errorf("last facet doesn't have 3 vertices")
+	}
+	return corners, nil
+}
@@ -1,208 +1,173 @@
 package main
 
-import (
-	"math"
-)
-
-// Translation
-type Translate struct {
-	object *Hittable
-	offset Vec3
-	bbox   AABB
-}
-
-func NewTranslate(object Hittable, offset *Vec3) *Hittable {
-	var thing Hittable = &Translate{
-		object: &object,
-		offset: *offset,
-		bbox:   *(object).bounding_box().AddOffset(offset),
-	}
+import "math"
 
-	return &thing
+// An object moved by a 4x4 matrix. Rays are taken into object space by the inverse, hits are brought back by the matrix,
+// with normals by its inverse transpose so they stay perpendicular under non-uniform scaling and shearing.
+type Transform struct {
+	object          Hittable
+	matrix, inverse Mat4 // Object to world transform and its inverse
+	bbox            AABB
 }
 
-func (tran *Translate) hit(ray *Ray, ray_tmin float64, ray_tmax float64, record *Hit) (ok bool) {
-	// Move the ray backwards by the offset
-	offset_ray := NewRay(*ray.origin.Sub(&tran.offset), ray.direction, ray.time)
-
-	// Determine whether an intersection exists along the offset ray (and if so, where)
-
-	if !(*tran.object).hit(&offset_ray, ray_tmin, ray_tmax, record) {
-		return false
+// Move object by matrix. Transforming a Transform folds the two matrices together rather than nesting them.
+func NewTransform(object Hittable, matrix Mat4) *Transform {
+	if inner, ok := object.(*Transform); ok {
+		object, matrix = inner.object, matrix.Mul(&inner.matrix)
 	}
 
-	record.point.IAdd(&tran.offset)
-	return true
-
+	inverse, ok := matrix.Inverse()
+	if !ok {
+		// A singular matrix flattens the object to nothing, so it can never be hit.
+		return &Transform{object: NewList(), matrix: matrix, inverse: inverse, bbox: *NewEmptyAABB()}
+	}
+	return &Transform{
+		object:  object,
+		matrix:  matrix,
+		inverse: inverse,
+		bbox:    *transformed_bounds(object, &matrix),
+	}
 }
 
-func (tran *Translate) bounding_box() (bounds *AABB) {
-	return &tran.bbox
+// Move object by offset
+func NewTranslate(object Hittable, offset *Vec3) *Transform {
+	return NewTransform(object, TranslationMat4(offset))
 }
 
-// Rotation, made to be general.
-type Rotate struct {
-	object                                                         *Hittable
-	alpha_cos, beta_cos, gamma_cos, alpha_sin, beta_sin, gamma_sin float64 // Cos and Sine in Radians for Yaw, Pitch and roll
-	bbox                                                           AABB
+// Rotate object by yaw (alpha, about z), pitch (beta, about y) and roll (gamma, about x) in degrees.
+func NewRotate(object Hittable, alpha, beta, gamma float64) *Transform {
+	return NewTransform(object, RotationMat4(alpha, beta, gamma))
 }
 
-func NewRotate(object Hittable, alpha, beta, gamma float64) *Rotate {
-	var rotate Rotate
-
-	temp_alpha := alpha * math.Pi / 180
-	temp_beta := beta * math.Pi / 180
-	temp_gamma := gamma * math.Pi / 180
-
-	rotate.alpha_cos = math.Cos(temp_alpha)
-	rotate.alpha_sin = math.Sin(temp_alpha)
-	rotate.beta_cos = math.Cos(temp_beta)
-	rotate.beta_sin = math.Sin(temp_beta)
-	rotate.gamma_cos = math.Cos(temp_gamma)
-	rotate.gamma_sin = math.Sin(temp_gamma)
-
-	rotate.object = &object
-	rotate.bbox = *(object).bounding_box()
-
-	min := NewVec3(math.Inf(1), math.Inf(1), math.Inf(1))
-	max := NewVec3(math.Inf(-1), math.Inf(-1), math.Inf(-1))
-
-	// bbox calculations
-	for i := 0; i < 2; i++ {
-		for j := 0; j < 2; j++ {
-			for k := 0; k < 2; k++ {
-				x := float64(i)*rotate.bbox.maxVec[0] + (1-float64(i))*rotate.bbox.minVec[0]
-				y := float64(j)*rotate.bbox.maxVec[1] + (1-float64(j))*rotate.bbox.minVec[1]
-				z := float64(k)*rotate.bbox.maxVec[2] + (1-float64(k))*rotate.bbox.minVec[2]
-
-				tester := rotate.RotateClockwise(NewVec3(x, y, z))
-
-				for c := 0; c < 3; c++ {
-					min[c] = math.Min(min[c], tester[c])
-					max[c] = math.Max(max[c], tester[c])
-				}
-			}
-		}
-	}
-
-	rotate.bbox = *NewAABB(*min, *max)
-	return &rotate
+// Rotate object angle degrees anticlockwise about axis
+func NewRotateAxis(object Hittable, axis *Vec3, angle float64) *Transform {
+	return NewTransform(object, AxisAngleMat4(axis, angle))
 }
 
-func (rot *Rotate) hit(ray *Ray, ray_tmin float64, ray_tmax float64, record *Hit) (ok bool) {
-
-	origin, direction := ray.origin, ray.direction
-
-	origin = *rot.RotateClockwise(&origin)
-	direction = *rot.RotateClockwise(&direction)
-
-	rotated := NewRay(origin, direction, ray.time)
-
-	// Determine whether an intersection exists in object space (and if so, where)
-	if !(*rot.object).hit(&rotated, ray_tmin, ray_tmax, record) {
-		return false
-	}
-
-	// Change the intersection point from object space to world space
-	point, normal := record.point, record.normal
-
-	record.point = *rot.RotateAntiClockWise(&point)
-	record.normal = *rot.RotateAntiClockWise(&normal)
-
-	return true
+// Rotate object by a quaternion
+func NewRotateQuaternion(object Hittable, rotation *Quaternion) *Transform {
+	return NewTransform(object, rotation.Mat4())
 }
 
-func (rot *Rotate) bounding_box() (bounds *AABB) {
-	return &rot.bbox
+// Rotate object the shortest way that turns direction from to point along to.
+func NewOrient(object Hittable, from, to *Vec3) *Transform {
+	return NewRotateQuaternion(object, QuaternionBetween(from, to))
 }
 
-// Scaling
-type Scale struct {
-	object    *Hittable
-	scale_fac *Vec3 // Scale factor in each axis
-	bbox      AABB
+// Rotate object so its +z axis points along direction and its +y axis towards up.
+func NewLookAt(object Hittable, direction, up *Vec3) *Transform {
+	return NewRotateQuaternion(object, QuaternionLookAt(direction, up))
 }
 
-func NewScale(object Hittable, x, y, z float64) *Hittable {
-	var thing Hittable = &Scale{
-		object:    &object,
-		scale_fac: NewVec3(x, y, z),
-		bbox:      *(object).bounding_box().Scale(NewVec3(x, y, z)),
-	}
+// Scale object by a factor along each axis
+func NewScale(object Hittable, x, y, z float64) *Transform {
+	return NewTransform(object, ScalingMat4(x, y, z))
+}
 
-	return &thing
+// Shear object, x_y is how far x moves per unit of y and so on.
+func NewShear(object Hittable, x_y, x_z, y_x, y_z, z_x, z_y float64) *Transform {
+	return NewTransform(object, ShearMat4(x_y, x_z, y_x, y_z, z_x, z_y))
 }
 
-func (scale *Scale) hit(ray *Ray, ray_tmin float64, ray_tmax float64, record *Hit) (ok bool) {
+func (transform *Transform) hit(ray *Ray, ray_tmin float64, ray_tmax float64, record *Hit) (ok bool) {
+	return transformed_hit(transform.object, &transform.matrix, &transform.inverse, ray, ray_tmin, ray_tmax, record)
+}
 
-	new_ray := NewRay(*ray.origin.Div(scale.scale_fac), *ray.direction.Div(scale.scale_fac), ray.time)
+// Intersect object moved by matrix, whose inverse is given too.
+func transformed_hit(object Hittable, matrix, inverse *Mat4, ray *Ray, ray_tmin float64, ray_tmax float64, record *Hit) (ok bool) {
+	// The direction is deliberately left unnormalized so t means the same distance along the ray in both spaces.
+	local := Ray{*inverse.TransformPoint(&ray.origin), *inverse.TransformVector(&ray.direction), ray.time}
 
 	// Determine whether an intersection exists in object space (and if so, where)
-	if !(*scale.object).hit(&new_ray, ray_tmin, ray_tmax, record) {
+	if !object.hit(&local, ray_tmin, ray_tmax, record) {
 		return false
 	}
+
 	// Change the intersection point from object space to world space
-	record.point = *record.point.Mult(scale.scale_fac)
-	record.normal = *record.normal.Mult(scale.scale_fac)
+	record.point = *matrix.TransformPoint(&record.point)
+	record.normal = *inverse.TransformNormal(&record.normal).Unit()
+	record.geometric_normal = *inverse.TransformNormal(&record.geometric_normal).Unit()
+	record.dpdu = *matrix.TransformVector(&record.dpdu)
+	record.dpdv = *matrix.TransformVector(&record.dpdv)
 	return true
 }
 
-func (scale *Scale) bounding_box() (bounds *AABB) {
-	return &scale.bbox
+// An emitter behind a Transform, sampled in the object's space and brought back out by the matrix so it can go in a light tree.
+type transformed_light struct {
+	light           Light
+	matrix, inverse *Mat4
 }
 
-// Shearing
-type Shear struct {
-	object                       *Hittable
-	x_y, x_z, y_x, y_z, z_x, z_y float64 // (axis to move)_(axis to move in relation to)
-	bbox                         AABB
+// Wrap each of lights, which are inside transform, so they're sampled where the transform puts them.
+func transform_lights(lights []Light, transform *Transform) []Light {
+	wrapped := make([]Light, len(lights))
+	for i, light := range lights {
+		wrapped[i] = &transformed_light{light, &transform.matrix, &transform.inverse}
+	}
+	return wrapped
 }
 
-func NewShear(object Hittable, x_y, x_z, y_x, y_z, z_x, z_y float64) *Shear {
-
-	var thing *Shear = &Shear{
-		&object,
-		x_y, x_z, y_x, y_z, z_x, z_y,
-		*(object).bounding_box(),
+func (light *transformed_light) sample_light(origin *Vec3, time float64) (direction Vec3, distance float64, emission Vec3, pdf float64) {
+	local, local_distance, emission, pdf := light.light.sample_light(light.inverse.TransformPoint(origin), time)
+	if pdf <= 0 {
+		return Vec3{}, 0, emission, 0
 	}
 
-	thing.bbox = *NewAABB(*thing.ApplyShear(&object.bounding_box().minVec), *thing.ApplyShear(&object.bounding_box().maxVec))
-
-	return thing
-}
-
-func (shear *Shear) ApplyShear(v1 *Vec3) *Vec3 {
-	return &Vec3{
-		v1[0] + v1[1]*shear.x_y + v1[2]*shear.x_z,
-		v1[0]*shear.y_x + v1[1] + v1[2]*shear.y_z,
-		v1[0]*shear.z_x + v1[1]*shear.z_y + v1[2],
+	offset := light.matrix.TransformVector(local.Scale(local_distance))
+	distance = offset.Magnitude()
+	if distance == 0 {
+		return Vec3{}, 0, emission, 0
 	}
-}
 
-func (shear *Shear) ReveseShear(v1 *Vec3) *Vec3 {
-	a, b, c, d, e, f := shear.x_y, shear.x_z, shear.y_x, shear.y_z, shear.z_x, shear.z_y
-	factor := (1 - a*c - b*e + a*d*e + b*c*f - d*f)
-	return &Vec3{
-		v1[0]*(1-d*f)/factor + v1[1]*(-a+b*f)/factor + v1[2]*(-b+a*d)/factor,
-		v1[0]*(-c+d*e)/factor + v1[1]*(1-b*e)/factor + v1[2]*(b*c-d)/factor,
-		v1[0]*(-e+c*f)/factor + v1[1]*(a*e-f)/factor + v1[2]*(1-a*c)/factor,
+	// The matrix squashes and stretches directions as well, changing the solid angle around the
+	// unit local direction d by det / |matrix d|³, so the pdf changes by the inverse of that.
+	stretch := distance / (local_distance * local.Magnitude()) // |matrix d|
+	pdf *= stretch * stretch * stretch / math.Abs(light.matrix.determinant3())
+	return *offset.Scale(1 / distance), distance, emission, pdf
+}
+
+func (light *transformed_light) light_bounds() LightBounds {
+	bounds := light.light.light_bounds()
+	bounds.bbox = *light.matrix.TransformAABB(&bounds.bbox)
+	bounds.w = *light.inverse.TransformNormal(&bounds.w).Unit()
+	// Areas grow about as the determinant to the power of 2/3, exactly so unless the object is squashed.
+	bounds.phi *= math.Pow(math.Abs(light.matrix.determinant3()), 2.0/3)
+	if _, ok := similarity_scale(light.matrix); !ok {
+		// Squashing turns normals by different amounts, so they may now point anywhere.
+		bounds.cos_theta_o = -1
 	}
+	return bounds
 }
 
-func (shear *Shear) hit(ray *Ray, ray_tmin float64, ray_tmax float64, record *Hit) (ok bool) {
-
-	new_ray := NewRay(*shear.ReveseShear(&ray.origin), *shear.ReveseShear(&ray.direction), ray.time)
-
-	// Determine whether an intersection exists in object space (and if so, where)
-	if !(*shear.object).hit(&new_ray, ray_tmin, ray_tmax, record) {
-		return false
-	}
-	// Change the intersection point from object space to world space
-	record.point = *shear.ApplyShear(&record.point)
-	record.normal = *shear.ApplyShear(&record.normal)
-	return true
+func (transform *Transform) bounding_box() (bounds *AABB) {
+	return &transform.bbox
 }
 
-func (shear *Shear) bounding_box() (bounds *AABB) {
-	return &shear.bbox
+// Bounds of object after transforming it by matrix. Spheres and lists are bounded piece by piece,
+// which is tighter than transforming the corners of their box.
+func transformed_bounds(object Hittable, matrix *Mat4) *AABB {
+	switch obj := object.(type) {
+	case *Sphere:
+		if obj.is_moving {
+			break
+		}
+		// An ellipsoid reaches as far along each axis as the length of that row of the matrix, times the radius.
+		center := matrix.TransformPoint(&obj.center)
+		var extent Vec3
+		for axis := 0; axis < 3; axis++ {
+			row := NewVec3(matrix[axis][0], matrix[axis][1], matrix[axis][2])
+			extent[axis] = row.Magnitude() * obj.radius
+		}
+		return NewAABB(*center.Sub(&extent), *center.Add(&extent))
+	case *Hit_List:
+		bounds := NewEmptyAABB()
+		for _, child := range obj.list {
+			bounds.IMerge(transformed_bounds(child, matrix))
+		}
+		return bounds
+	case *Transform:
+		combined := matrix.Mul(&obj.matrix)
+		return transformed_bounds(obj.object, &combined)
+	}
+	return matrix.TransformAABB(object.bounding_box())
 }